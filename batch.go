@@ -0,0 +1,58 @@
+package shm_master
+
+import (
+	"shm_master/internal/engine"
+	"shm_master/internal/errs"
+)
+
+// WriteBatch 暂存一组 Set/Del，Commit 时原子写入：崩溃后 Recover 要么重放整批，要么一条都不重放。
+// WriteBatch 不是并发安全的。
+type WriteBatch struct {
+	db  *DB
+	ops []engine.BatchOp
+}
+
+// NewBatch 创建一个空的 WriteBatch。
+func (db *DB) NewBatch() *WriteBatch {
+	return &WriteBatch{db: db}
+}
+
+// Set 暂存一次写入；value 会被复制，调用方之后可以复用该切片。
+func (b *WriteBatch) Set(key string, value []byte) error {
+	if len(key) == 0 || len(key) > int(^uint16(0)) {
+		return errs.ErrBadArgument
+	}
+	if len(value) == 0 || len(value) > int(^uint32(0)) {
+		return errs.ErrBadArgument
+	}
+	b.ops = append(b.ops, engine.BatchOp{Key: key, Value: append([]byte(nil), value...)})
+	return nil
+}
+
+// Del 暂存一次删除。
+func (b *WriteBatch) Del(key string) error {
+	if len(key) == 0 || len(key) > int(^uint16(0)) {
+		return errs.ErrBadArgument
+	}
+	b.ops = append(b.ops, engine.BatchOp{Key: key, Del: true})
+	return nil
+}
+
+// Len 返回已暂存的操作数。
+func (b *WriteBatch) Len() int { return len(b.ops) }
+
+// Reset 清空暂存的操作，以便复用。
+func (b *WriteBatch) Reset() { b.ops = b.ops[:0] }
+
+// Commit 原子提交暂存的操作并清空批次。整批的记录与 value 必须能放进一个空段，
+// 且 value 不得大到需要分块，否则返回 ErrBadArgument。
+func (b *WriteBatch) Commit() error {
+	if b.db == nil || b.db.e == nil {
+		return nil
+	}
+	if err := b.db.e.Apply(b.ops); err != nil {
+		return err
+	}
+	b.Reset()
+	return nil
+}
@@ -0,0 +1,31 @@
+package shm_master
+
+import "shm_master/internal/engine"
+
+// CheckReport Check 的结果：段数、key 数与发现的全部不一致。
+type CheckReport = engine.CheckReport
+
+// Problem Check 发现的一处不一致。
+type Problem = engine.Problem
+
+// ProblemKind 不一致的类型。
+type ProblemKind = engine.ProblemKind
+
+const (
+	ProblemCRC        = engine.ProblemCRC
+	ProblemOverlap    = engine.ProblemOverlap
+	ProblemInLog      = engine.ProblemInLog
+	ProblemOutOfRange = engine.ProblemOutOfRange
+	ProblemOrphan     = engine.ProblemOrphan
+	ProblemFreeLive   = engine.ProblemFreeLive
+	ProblemTruncated  = engine.ProblemTruncated
+)
+
+// Check 校验 Recover 容忍而不报告的不一致：校验和错误、存活 value 之间及与 log 区、freelist 的重叠、
+// 越界的索引项、孤儿段文件与被截断的 log。不修改数据，通常对只读打开的 DB 调用。
+func (db *DB) Check() (*CheckReport, error) {
+	if db == nil || db.e == nil {
+		return &CheckReport{}, nil
+	}
+	return db.e.Check()
+}
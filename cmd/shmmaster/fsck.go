@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"shm_master/internal/engine"
+)
+
+// exitProblems fsck 发现不一致时的退出码。
+const exitProblems = 3
+
+func runFsck(args []string) int {
+	fl := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	asJSON := fl.Bool("json", false, "print a JSON report")
+	segSizeFlag := fl.Int64("segsize", 0, "segment size in bytes (default: size of the first segment file)")
+	fl.Usage = func() {
+		fmt.Fprintln(fl.Output(), "usage: shmmaster fsck [-json] [-segsize n] <base>")
+		fl.PrintDefaults()
+	}
+	if err := fl.Parse(args); err != nil {
+		return 2
+	}
+	if fl.NArg() != 1 {
+		fl.Usage()
+		return 2
+	}
+	base := fl.Arg(0)
+	size := *segSizeFlag
+	if size == 0 {
+		var err error
+		if size, err = segSize(base); err != nil {
+			return fail(err)
+		}
+	}
+	db, err := engine.OpenReadOnly(base, size)
+	if err != nil {
+		return fail(err)
+	}
+	defer db.Close()
+	rep, err := db.Check()
+	if err != nil {
+		return fail(err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			return fail(err)
+		}
+	} else {
+		for _, p := range rep.Problems {
+			fmt.Println(p)
+		}
+		fmt.Printf("%s: %d segments, %d keys, %d problems\n", base, rep.Segments, rep.Keys, len(rep.Problems))
+	}
+	if !rep.OK() {
+		return exitProblems
+	}
+	return 0
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"shm_master/internal/inspect"
+	"strconv"
+	"text/tabwriter"
+)
+
+func runInspect(args []string) int {
+	fl := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	asJSON := fl.Bool("json", false, "print a JSON report")
+	seg := fl.Int("seg", -1, "only print this segment id")
+	showLayout := fl.Bool("layout", true, "print the value-region layout")
+	fl.Usage = func() {
+		fmt.Fprintln(fl.Output(), "usage: shmmaster inspect [-json] [-seg id] [-layout=false] <base>")
+		fl.PrintDefaults()
+	}
+	if err := fl.Parse(args); err != nil {
+		return 2
+	}
+	if fl.NArg() != 1 {
+		fl.Usage()
+		return 2
+	}
+	opts := inspect.Options{Layout: *showLayout}
+	if *seg >= 0 {
+		id := uint32(*seg)
+		opts.Seg = &id
+	}
+	rep, err := inspect.Inspect(fl.Arg(0), opts)
+	if err != nil {
+		return fail(err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			return fail(err)
+		}
+		return 0
+	}
+	if err := printReport(os.Stdout, rep); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+func printReport(out io.Writer, rep *inspect.Report) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "base %s  db_id %s  manifest %v\n", rep.Base, orDash(rep.DBID), rep.Manifest)
+	for _, s := range rep.Segments {
+		fmt.Fprintf(w, "\nsegment %d  %s  size %d  state %s\n", s.ID, s.Path, s.Size, s.State)
+		switch {
+		case s.Legacy:
+			fmt.Fprintln(w, "  superblock: none (legacy)")
+		case s.Super != nil:
+			fmt.Fprintf(w, "  superblock: v%d seg_size %d seg_id %d created %s db_id %s\n",
+				s.Super.Version, s.Super.SegSize, s.Super.SegID, s.Super.CreatedAt.Format("2006-01-02T15:04:05Z"), s.Super.DBID)
+		}
+		if s.SuperErr != "" {
+			fmt.Fprintf(w, "  superblock error: %s\n", s.SuperErr)
+		}
+		fmt.Fprintf(w, "  log [%d, %d)  values [%d, %d)  records %d\n", s.LogStart, s.LogEnd, s.ValEnd, s.Size, len(s.Records))
+		if len(s.Records) > 0 {
+			fmt.Fprintln(w, "  OFF\tSEQ\tOP\tFLAGS\tKEY\tVALSEG\tVALOFF\tVALLEN\tCRC\tLIVE\t")
+			for _, r := range s.Records {
+				crc := r.CRC
+				if r.ChunkCRC != "" {
+					crc += "/chunks:" + r.ChunkCRC
+				}
+				val := "-\t-\t-"
+				if r.Op == "put" {
+					val = fmt.Sprintf("%d\t%d\t%d", r.ValSeg, r.ValOff, r.ValLen)
+				}
+				live := ""
+				if r.Live {
+					live = "*"
+				}
+				fmt.Fprintf(w, "  %d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t\n", r.Off, r.Seq, r.Op, orDash(r.Flags), keyText(r), val, orDash(crc), live)
+			}
+		}
+		if s.Stop != nil {
+			fmt.Fprintf(w, "  log stops at %d: %s (%d bytes discarded)\n", s.Stop.Off, s.Stop.Reason, s.Stop.Discarded)
+		}
+		if len(s.Blocks) > 0 {
+			fmt.Fprintln(w, "  value layout:")
+			fmt.Fprintln(w, "  OFF\tLEN\tKIND\tREFS\t")
+			for _, b := range s.Blocks {
+				refs := "-"
+				for i, ref := range b.Refs {
+					if i == 0 {
+						refs = ""
+					} else {
+						refs += " "
+					}
+					refs += fmt.Sprintf("%s@%d:%d#%d", strconv.Quote(ref.Key), ref.Seg, ref.Off, ref.Seq)
+				}
+				fmt.Fprintf(w, "  %d\t%d\t%s\t%s\t\n", b.Off, b.Len, b.Kind, refs)
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// keyText 返回记录 key 的可打印形式，Begin/Commit 显示其 Ext。
+func keyText(r inspect.Record) string {
+	switch r.Op {
+	case "begin":
+		return fmt.Sprintf("(ops=%d)", r.Ext)
+	case "commit":
+		return fmt.Sprintf("(begin=%d)", r.Ext)
+	}
+	return strconv.Quote(r.Key)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
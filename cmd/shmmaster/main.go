@@ -0,0 +1,72 @@
+// Command shmmaster 是 shm_master 数据文件的离线维护工具。
+//
+//	shmmaster inspect [-json] [-seg id] [-layout=false] <base>
+//	shmmaster fsck [-json] [-segsize n] <base>
+//	shmmaster repair [-segsize n] [-report file] <src> <dst>
+//
+// 退出码：0 成功，1 出错，2 用法错误，3 fsck 发现不一致。
+package main
+
+import (
+	"fmt"
+	"os"
+	"shm_master/internal/fs"
+)
+
+// command 一个子命令，run 返回进程退出码。
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands = []command{
+	{"inspect", "dump segments, log records and value layout", runInspect},
+	{"fsck", "verify a database read-only and report inconsistencies", runFsck},
+	{"repair", "salvage intact records into a new database, leaving the source untouched", runRepair},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			os.Exit(c.run(os.Args[2:]))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "shmmaster: unknown command %q\n", os.Args[1])
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: shmmaster <command> [flags] <base>")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+}
+
+// fail 打印错误并返回退出码 1。
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "shmmaster:", err)
+	return 1
+}
+
+// segSize 以 base 第一个段文件的大小作为段大小。
+func segSize(base string) (int64, error) {
+	ids, err := fs.ListSegIDs(base)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, fmt.Errorf("no segment files for %s", base)
+	}
+	st, err := os.Stat(fs.SegPath(base, ids[0]))
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"shm_master/internal/salvage"
+)
+
+func runRepair(args []string) int {
+	fl := flag.NewFlagSet("repair", flag.ContinueOnError)
+	segSizeFlag := fl.Int64("segsize", 0, "segment size of the new database (default: size of the first source segment file)")
+	reportPath := fl.String("report", "", "where to write the JSON report (default: <dst>.repair.json)")
+	fl.Usage = func() {
+		fmt.Fprintln(fl.Output(), "usage: shmmaster repair [-segsize n] [-report file] <src> <dst>")
+		fl.PrintDefaults()
+	}
+	if err := fl.Parse(args); err != nil {
+		return 2
+	}
+	if fl.NArg() != 2 {
+		fl.Usage()
+		return 2
+	}
+	src, dst := fl.Arg(0), fl.Arg(1)
+	size := *segSizeFlag
+	if size == 0 {
+		var err error
+		if size, err = segSize(src); err != nil {
+			return fail(err)
+		}
+	}
+	rep, err := salvage.Salvage(src, dst, size)
+	if err != nil {
+		return fail(err)
+	}
+	path := *reportPath
+	if path == "" {
+		path = dst + ".repair.json"
+	}
+	b, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return fail(err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fail(err)
+	}
+
+	var regions, lost int
+	for _, s := range rep.Segments {
+		regions += len(s.Corrupt)
+	}
+	for _, l := range rep.Lost {
+		if !l.Superseded {
+			lost++
+		}
+	}
+	fmt.Printf("%s -> %s: %d keys written, %d deleted, %d expired\n", src, dst, rep.Keys, rep.Deleted, rep.Expired)
+	fmt.Printf("%d corrupt regions skipped, %d records lost (%d without a newer salvaged version)\n", regions, len(rep.Lost), lost)
+	for _, s := range rep.Skipped {
+		fmt.Println("skipped", s)
+	}
+	fmt.Println("report:", path)
+	return 0
+}
@@ -2,11 +2,34 @@ package consts
 
 // Header Const
 const (
-	Magic      = uint32(0x4B564C47) // 'KVLG' 随便选
-	Version    = uint16(1)
-	FlagPut    = uint16(1)
-	FlagDel    = uint16(2)
-	HeaderSize = 4 + 2 + 2 + 2 + 2 + 4 + 8 + 4 // 20 bytes（含 reserved）
+	Magic    = uint32(0x4B564C47) // 'KVLG' 随便选
+	Version1 = uint16(1)
+	Version2 = uint16(2)
+	Version  = Version2 // 新写入的记录版本
+	FlagPut  = uint16(1)
+	FlagDel  = uint16(2)
+	// FlagBegin/FlagCommit 为不带 key 的批量边界记录：Begin.Ext 为批内操作数，Commit.Ext 为 Begin.Seq。
+	FlagBegin  = uint16(3)
+	FlagCommit = uint16(4)
+
+	// HeaderSizeV1 v1 头：magic/ver/flags/keyLen/rsv/valLen/valOff/crc。
+	HeaderSizeV1 = 4 + 2 + 2 + 2 + 2 + 4 + 8 + 4 // 28 bytes
+	// HeaderSize v2 头：在 v1 字段后增加 valSeg/valCRC/seq/ext，crc 位于末尾。
+	HeaderSize = 4 + 2 + 2 + 2 + 2 + 4 + 8 + 4 + 4 + 8 + 8 + 4 // 52 bytes
+
+	// FlagOpMask 取出 Flags 中的操作类型（FlagPut/FlagDel），高位留给标志位。
+	FlagOpMask = uint16(0x00FF)
+	// FlagTTL 仅用于 v2 Put：置位时 Ext 为过期时间（Unix 纳秒）。
+	FlagTTL = uint16(1) << 14
+	// FlagChunked 仅用于 v2 Put：value 区存放的是分块清单，真正的数据分散在清单列出的各块中。
+	FlagChunked = uint16(1) << 13
+)
+
+// Superblock Const：每个段文件开头的固定超级块。
+const (
+	SuperMagic   = uint32(0x4B565342) // 'KVSB'
+	SuperVersion = uint16(1)
+	SuperSize    = 64
 )
 
 const (
@@ -0,0 +1,19 @@
+package shm_master
+
+import "time"
+
+// Refresh 只读打开时追上写者的最新写入：打开新追加的段并重放新记录。写者 DB 上为空操作。
+func (db *DB) Refresh() error {
+	if db == nil || db.e == nil {
+		return nil
+	}
+	return db.e.Refresh()
+}
+
+// StartFollow 只读打开时启动后台跟随协程，每隔 interval 执行一次 Refresh，Close 时停止。
+func (db *DB) StartFollow(interval time.Duration) error {
+	if db == nil || db.e == nil {
+		return nil
+	}
+	return db.e.StartFollow(interval)
+}
@@ -1,93 +1,253 @@
 package engine
 
 import (
-	"encoding/binary"
+	"fmt"
 	"shm_master/consts"
 	"shm_master/internal/errs"
 	"shm_master/internal/index"
 	"shm_master/internal/record"
 	"shm_master/internal/segment"
+	"time"
 )
 
 func (db *DB) lastSeg() *segment.Segment {
 	return db.segMgr.Last()
 }
 
+// recSize 返回 key 对应记录在 log 区占用的字节数。
+func recSize(key string) uint64 {
+	return uint64(consts.HeaderSize) + uint64(len(key))
+}
+
+// nextSeq 分配下一个记录序列号，调用方需持有 writeMu。
+func (db *DB) nextSeq() uint64 {
+	db.seq++
+	return db.seq
+}
+
+// addLive 把 e 计入 value 段（含各分块所在段）与 log 段的存活字节数。
+func (db *DB) addLive(key string, e index.Entry) {
+	if seg := db.segMgr.Seg(e.SegID); seg != nil {
+		seg.AddLive(uint64(segment.SizeClass(e.ValLen)))
+	}
+	for _, c := range db.chunksOf(e) {
+		if seg := db.segMgr.Seg(c.Seg); seg != nil {
+			seg.AddLive(uint64(segment.SizeClass(c.Len)))
+		}
+	}
+	if seg := db.segMgr.Seg(e.LogSeg); seg != nil {
+		seg.AddLive(recSize(key))
+		seg.AddKey()
+	}
+}
+
+// subLive 从 value 段（含各分块所在段）与 log 段的存活字节数中扣除 e。
+func (db *DB) subLive(key string, e index.Entry) {
+	if seg := db.segMgr.Seg(e.SegID); seg != nil {
+		seg.SubLive(uint64(segment.SizeClass(e.ValLen)))
+	}
+	for _, c := range db.chunksOf(e) {
+		if seg := db.segMgr.Seg(c.Seg); seg != nil {
+			seg.SubLive(uint64(segment.SizeClass(c.Len)))
+		}
+	}
+	if seg := db.segMgr.Seg(e.LogSeg); seg != nil {
+		seg.SubLive(recSize(key))
+		seg.SubKey()
+	}
+}
+
+// freeEntry 归还 e 的 value 块；有打开的快照时推迟到快照释放，调用方需持有 writeMu。
+func (db *DB) freeEntry(e index.Entry) {
+	if db.nsnap.Load() > 0 {
+		db.deferred = append(db.deferred, deferredFree{seq: db.seq, e: e})
+		return
+	}
+	db.releaseBlock(e)
+}
+
+// releaseBlock 把 e 的 value 块（分块 value 还包括各分块）归还到所在段的 freelist。
+func (db *DB) releaseBlock(e index.Entry) {
+	for _, c := range db.chunksOf(e) {
+		if seg := db.segMgr.Seg(c.Seg); seg != nil {
+			seg.FreeBlock(c.Off, c.Len)
+		}
+	}
+	if seg := db.segMgr.Seg(e.SegID); seg != nil {
+		seg.FreeBlock(e.ValOff, e.ValLen)
+	}
+}
+
+// putEntry 更新索引并维护各段存活字节数，返回旧索引项。调用方需持有 writeMu。
+func (db *DB) putEntry(key string, e index.Entry) (index.Entry, bool) {
+	if db.nsnap.Load() > 0 {
+		db.snapMu.Lock()
+		defer db.snapMu.Unlock()
+	}
+	old, hadOld := db.idx.Get(key)
+	db.saveUndo(key, old, hadOld)
+	if hadOld {
+		db.subLive(key, old)
+	}
+	db.addLive(key, e)
+	db.idx.Set(key, e)
+	return old, hadOld
+}
+
+// dropEntry 删除索引项并维护存活字节数，返回旧索引项。调用方需持有 writeMu。
+func (db *DB) dropEntry(key string) (index.Entry, bool) {
+	if db.nsnap.Load() > 0 {
+		db.snapMu.Lock()
+		defer db.snapMu.Unlock()
+	}
+	old, hadOld := db.idx.Get(key)
+	if !hadOld {
+		return old, false
+	}
+	db.saveUndo(key, old, true)
+	db.subLive(key, old)
+	db.idx.Del(key)
+	return old, true
+}
+
 func (db *DB) Set(key string, value []byte) error {
+	if m := db.metrics; m != nil {
+		start := time.Now()
+		err := db.set(key, value)
+		m.observe(opSet, start, err)
+		return err
+	}
+	return db.set(key, value)
+}
+
+func (db *DB) set(key string, value []byte) error {
+	if err := db.writable(); err != nil {
+		return err
+	}
 	if len(key) == 0 || len(key) > int(^uint16(0)) {
 		return errs.ErrBadArgument
 	}
 	if len(value) == 0 || len(value) > int(^uint32(0)) {
 		return errs.ErrBadArgument
 	}
-	keyLen := len(key)
-	valLen := uint32(len(value))
-	recTotal := uint64(consts.HeaderSize) + uint64(keyLen)
-
 	db.writeMu.Lock()
 	defer db.writeMu.Unlock()
+	return db.setLocked(key, value)
+}
+
+// setLocked 写入一条 Put 记录、更新索引并通知订阅方，调用方需持有 writeMu。
+func (db *DB) setLocked(key string, value []byte) error {
+	if err := db.putValue(key, value, 0); err != nil {
+		return err
+	}
+	db.notify(OpSet, key, db.seq)
+	return nil
+}
+
+// putMeta 写入 Put 记录时 value 之外的元数据。
+type putMeta struct {
+	valCRC   uint32
+	expireAt int64 // 0 表示永不过期
+	chunked  bool  // value 为分块清单
+}
+
+// writePut 以给定的 value 校验和与过期时间写入 Put 记录；压缩搬迁时沿用原校验和，
+// 避免把已损坏的 value 重新“签名”成合法数据。
+func (db *DB) writePut(key string, value []byte, m putMeta) error {
+	valLen := uint32(len(value))
+	recTotal := recSize(key)
+
 	seg := db.lastSeg()
 	if seg == nil || seg.GetData() == nil {
 		return errs.ErrClosed
 	}
-	valOff, ok := seg.Alloc(valLen, recTotal)
+	vseg, valOff, ok := db.segMgr.Alloc(valLen, recTotal)
 	if !ok {
-		db.lifeMu.Lock()
-		newSeg, err := db.segMgr.ApnSeg()
-		db.lifeMu.Unlock()
+		newSeg, err := db.appendSeg()
 		if err != nil {
 			return err
 		}
 		seg = newSeg
-		valOff, ok = seg.Alloc(valLen, recTotal)
+		vseg, valOff, ok = db.segMgr.Alloc(valLen, recTotal)
 		if !ok {
 			return errs.ErrNoSpace
 		}
 	}
-	data := seg.GetData()
-	copy(data[valOff:valOff+uint64(valLen)], value)
+	copy(vseg.GetData()[valOff:valOff+uint64(valLen)], value)
+	vseg.MarkDirty(valOff, uint64(valLen))
 	off := seg.LogEnd()
+	seq := db.nextSeq()
 	h := record.Header{
-		Magic:  consts.Magic,
-		Ver:    consts.Version,
 		Flags:  consts.FlagPut,
-		KeyLen: uint16(keyLen),
 		ValLen: valLen,
 		ValOff: valOff,
-		CRC32:  0,
+		ValSeg: vseg.ID(),
+		ValCRC: m.valCRC,
+		Seq:    seq,
+	}
+	if m.expireAt != 0 {
+		h.Flags |= consts.FlagTTL
+		h.Ext = uint64(m.expireAt)
 	}
-	record.EncodeHeader(data[off:off+consts.HeaderSize], h)
-	keyStart := off + consts.HeaderSize
-	keyEnd := keyStart + uint64(h.KeyLen)
-	copy(data[keyStart:keyEnd], key)
-	crc := record.CalcCRC(consts.FlagPut, uint16(keyLen), valLen, valOff, data[keyStart:keyEnd])
-	binary.LittleEndian.PutUint32(data[off+24:off+28], crc)
-	seg.SetLogEnd(off + recTotal)
+	if m.chunked {
+		h.Flags |= consts.FlagChunked
+	}
+	n := record.Write(seg.GetData()[off:off+recTotal], h, key)
+	seg.SetLogEnd(off + n)
+	seg.MarkDirty(off, n)
 
-	old, hadOld := db.idx.Get(key)
-	if hadOld && old.SegID == seg.ID() {
-		seg.FreeBlock(old.ValOff, old.ValLen)
+	old, hadOld := db.putEntry(key, index.Entry{
+		SegID:    vseg.ID(),
+		ValOff:   valOff,
+		ValLen:   valLen,
+		LogSeg:   seg.ID(),
+		ValCRC:   m.valCRC,
+		HasCRC:   true,
+		Seq:      seq,
+		ExpireAt: m.expireAt,
+		Chunked:  m.chunked,
+	})
+	if hadOld {
+		db.freeEntry(old)
 	}
-	db.idx.Set(key, index.Entry{SegID: seg.ID(), ValOff: valOff, ValLen: valLen})
-	return nil
+	return db.commitSync()
 }
 
 func (db *DB) Get(key string) ([]byte, bool, error) {
+	if m := db.metrics; m != nil {
+		start := time.Now()
+		v, ok, err := db.get(key)
+		m.observe(opGet, start, err)
+		return v, ok, err
+	}
+	return db.get(key)
+}
+
+func (db *DB) get(key string) ([]byte, bool, error) {
 	db.lifeMu.RLock()
 	defer db.lifeMu.RUnlock()
-	segs := db.segMgr.Segments()
-	if len(segs) == 0 {
+	if db.segMgr.Last() == nil {
 		return nil, false, errs.ErrClosed
 	}
 	e, ok := db.idx.Get(key)
 	if !ok {
 		return nil, false, nil
 	}
-	if int(e.SegID) >= len(segs) {
+	return db.value(key, e, db.segMgr.Seg(e.SegID), time.Now().UnixNano())
+}
+
+// value 从 seg 中取出 e 指向的 value，并按需校验 CRC；now 为判断过期的时刻。调用方需持有 lifeMu 读锁。
+func (db *DB) value(key string, e index.Entry, seg *segment.Segment, now int64) ([]byte, bool, error) {
+	if e.ExpireAt != 0 && e.Expired(now) {
+		return nil, false, nil
+	}
+	if e.Corrupt {
+		return nil, false, corruptKey(key)
+	}
+	if seg == nil {
 		return nil, false, errs.ErrCorrupt
 	}
-	seg := segs[e.SegID]
-	if seg == nil || seg.GetData() == nil {
+	if seg.GetData() == nil {
 		return nil, false, errs.ErrClosed
 	}
 	data := seg.GetData()
@@ -96,7 +256,24 @@ func (db *DB) Get(key string) ([]byte, bool, error) {
 	if end > uint64(len(data)) {
 		return nil, false, errs.ErrCorrupt
 	}
-	return data[start:end], true, nil
+	val := data[start:end]
+	if e.Chunked {
+		return db.assemble(key, e)
+	}
+	if e.HasCRC && (e.Unverified || db.verifyOnGet.Load()) && record.ValueCRC(val) != e.ValCRC {
+		return nil, false, corruptKey(key)
+	}
+	return val, true, nil
+}
+
+// SetVerifyOnGet 开启后 Get 会重新计算 value 的 CRC32C 并与记录比对。
+func (db *DB) SetVerifyOnGet(on bool) {
+	db.verifyOnGet.Store(on)
+}
+
+// corruptKey 返回指明具体 key 的 ErrCorrupt。
+func corruptKey(key string) error {
+	return fmt.Errorf("%w: key %q", errs.ErrCorrupt, key)
 }
 
 func (db *DB) GetCopy(key string) ([]byte, bool, error) {
@@ -111,49 +288,61 @@ func (db *DB) GetCopy(key string) ([]byte, bool, error) {
 }
 
 func (db *DB) Del(key string) error {
+	if m := db.metrics; m != nil {
+		start := time.Now()
+		err := db.del(key)
+		m.observe(opDel, start, err)
+		return err
+	}
+	return db.del(key)
+}
+
+func (db *DB) del(key string) error {
+	if err := db.writable(); err != nil {
+		return err
+	}
 	if len(key) == 0 || len(key) > int(^uint16(0)) {
 		return errs.ErrBadArgument
 	}
-	recTotal := uint64(consts.HeaderSize) + uint64(len(key))
-
 	db.writeMu.Lock()
 	defer db.writeMu.Unlock()
+	_, existed := db.idx.Get(key)
+	if err := db.delLocked(key); err != nil {
+		return err
+	}
+	if existed {
+		db.notify(OpDel, key, db.seq)
+	}
+	return nil
+}
+
+// delLocked 写入一条 Del 记录（墓碑）并删除索引，调用方需持有 writeMu。
+func (db *DB) delLocked(key string) error {
+	recTotal := recSize(key)
+
 	seg := db.lastSeg()
 	if seg == nil || seg.GetData() == nil {
 		return errs.ErrClosed
 	}
 	if seg.LogEnd()+recTotal > seg.ValEnd() {
-		db.lifeMu.Lock()
-		newSeg, err := db.segMgr.ApnSeg()
-		db.lifeMu.Unlock()
+		newSeg, err := db.appendSeg()
 		if err != nil {
 			return err
 		}
 		seg = newSeg
 	}
-	data := seg.GetData()
 	off := seg.LogEnd()
-	h := record.Header{
-		Magic:  consts.Magic,
-		Ver:    consts.Version,
-		Flags:  consts.FlagDel,
-		KeyLen: uint16(len(key)),
-		ValLen: 0,
-		ValOff: 0,
-		CRC32:  0,
-	}
-	keyStart := off + consts.HeaderSize
-	record.EncodeHeader(data[off:keyStart], h)
-	copy(data[keyStart:keyStart+uint64(h.KeyLen)], key)
-	keyBytes := data[keyStart : keyStart+uint64(h.KeyLen)]
-	crc := record.CalcCRC(consts.FlagDel, h.KeyLen, 0, 0, keyBytes)
-	binary.LittleEndian.PutUint32(data[off+24:off+28], crc)
-	seg.SetLogEnd(off + recTotal)
+	n := record.Write(seg.GetData()[off:off+recTotal], record.Header{
+		Flags: consts.FlagDel,
+		Seq:   db.nextSeq(),
+	}, key)
+	seg.SetLogEnd(off + n)
+	seg.MarkDirty(off, n)
+	seg.AddTomb()
 
-	old, hadOld := db.idx.Get(key)
-	if hadOld && old.SegID == seg.ID() {
-		seg.FreeBlock(old.ValOff, old.ValLen)
+	old, hadOld := db.dropEntry(key)
+	if hadOld {
+		db.freeEntry(old)
 	}
-	db.idx.Del(key)
-	return nil
+	return db.commitSync()
 }
@@ -0,0 +1,28 @@
+package engine
+
+import (
+	"errors"
+	"shm_master/internal/errs"
+	"strings"
+	"testing"
+)
+
+func TestGetVerifiesValueCRC(t *testing.T) {
+	db, _ := openTestDB(t)
+	if err := db.Set("player:1", []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	b, ok, err := db.Get("player:1")
+	if err != nil || !ok {
+		t.Fatalf("Get: ok=%v err=%v", ok, err)
+	}
+	b[0] ^= 0xff // 模拟 value 区位翻转
+	if _, _, err := db.Get("player:1"); err != nil {
+		t.Fatalf("verification is off by default, got %v", err)
+	}
+	db.SetVerifyOnGet(true)
+	_, _, err = db.Get("player:1")
+	if !errors.Is(err, errs.ErrCorrupt) || !strings.Contains(err.Error(), "player:1") {
+		t.Fatalf("want ErrCorrupt naming the key, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"errors"
+	"shm_master/consts"
+	"shm_master/internal/errs"
+	"shm_master/internal/index"
+	"shm_master/internal/record"
+	"shm_master/internal/segment"
+	"time"
+)
+
+// BatchOp 批量写中的一项操作；Del 为 true 时忽略 Value。
+type BatchOp struct {
+	Key   string
+	Value []byte
+	Del   bool
+}
+
+// errNeedSeg 表示活跃段放不下整批记录，需要追加新段后重试。
+var errNeedSeg = errors.New("db: batch needs a new segment")
+
+// Apply 原子提交一批 Set/Del：Begin、各操作记录、Commit 连续写在同一个活跃段，
+// Recover 只重放带有匹配 Commit 的批次。索引在 lifeMu 写锁下一次性更新，
+// 并发的 Get 要么看到整批之前、要么看到整批之后的状态。
+// 整批的记录与 value 块须能放进一个空段，且 value 不得大到需要分块，否则返回 ErrBadArgument。
+func (db *DB) Apply(ops []BatchOp) error {
+	if m := db.metrics; m != nil {
+		start := time.Now()
+		err := db.apply(ops)
+		m.observe(opBatch, start, err)
+		return err
+	}
+	return db.apply(ops)
+}
+
+func (db *DB) apply(ops []BatchOp) error {
+	if err := db.writable(); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if len(op.Key) == 0 || len(op.Key) > int(^uint16(0)) {
+			return errs.ErrBadArgument
+		}
+		if !op.Del && (len(op.Value) == 0 || len(op.Value) > int(^uint32(0))) {
+			return errs.ErrBadArgument
+		}
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	if !db.batchFits(ops) {
+		return errs.ErrBadArgument
+	}
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	events, err := db.applyLocked(ops)
+	if err == nil {
+		return db.commitBatch(events)
+	}
+	if !errors.Is(err, errNeedSeg) {
+		return err
+	}
+	_, err = db.appendSeg()
+	if err != nil {
+		return err
+	}
+	events, err = db.applyLocked(ops)
+	if err == nil {
+		return db.commitBatch(events)
+	}
+	if !errors.Is(err, errNeedSeg) {
+		return err
+	}
+	return errs.ErrNoSpace
+}
+
+// batchFits 报告 ops 能否放进一个空段；放不下的批次追加多少个段都无法提交。
+func (db *DB) batchFits(ops []BatchOp) bool {
+	need := 2 * uint64(consts.HeaderSize)
+	for _, op := range ops {
+		need += recSize(op.Key)
+		if op.Del {
+			continue
+		}
+		// 分块 value 需要多个块乃至多个段，不能放进单段的批次。
+		if len(op.Value) > 2*db.chunkSize() {
+			return false
+		}
+		need += uint64(segment.SizeClass(uint32(len(op.Value))))
+	}
+	return need <= uint64(db.segSize-consts.SuperSize)
+}
+
+// commitBatch 按刷盘策略提交整批，成功后才把批内变更通知订阅方，与 setLocked 一致。调用方需持有 writeMu。
+func (db *DB) commitBatch(events []Event) error {
+	if err := db.commitSync(); err != nil {
+		return err
+	}
+	for _, ev := range events {
+		db.notify(ev.Op, ev.Key, ev.Seq)
+	}
+	return nil
+}
+
+// batchAlloc 记录批内一次 value 分配。
+type batchAlloc struct {
+	seg *segment.Segment
+	off uint64
+}
+
+// applyLocked 先为所有 value 分配空间，全部成功后再写记录并更新索引；返回待通知的变更，
+// 没有订阅方时为 nil。调用方需持有 writeMu。
+func (db *DB) applyLocked(ops []BatchOp) ([]Event, error) {
+	seg := db.lastSeg()
+	if seg == nil || seg.GetData() == nil {
+		return nil, errs.ErrClosed
+	}
+	logTotal := 2 * uint64(consts.HeaderSize)
+	for _, op := range ops {
+		logTotal += recSize(op.Key)
+	}
+	if seg.LogEnd()+logTotal > seg.ValEnd() {
+		return nil, errNeedSeg
+	}
+	allocs := make([]batchAlloc, len(ops))
+	for i, op := range ops {
+		if op.Del {
+			continue
+		}
+		vseg, off, ok := db.segMgr.Alloc(uint32(len(op.Value)), logTotal)
+		if !ok {
+			for j := 0; j < i; j++ {
+				if allocs[j].seg != nil {
+					allocs[j].seg.FreeBlock(allocs[j].off, uint32(len(ops[j].Value)))
+				}
+			}
+			return nil, errNeedSeg
+		}
+		allocs[i] = batchAlloc{seg: vseg, off: off}
+	}
+
+	data := seg.GetData()
+	start := seg.LogEnd()
+	off := start
+	begin := db.nextSeq()
+	off += record.Write(data[off:], record.Header{Flags: consts.FlagBegin, Seq: begin, Ext: uint64(len(ops))}, "")
+	entries := make([]index.Entry, len(ops))
+	for i, op := range ops {
+		seq := db.nextSeq()
+		if op.Del {
+			off += record.Write(data[off:], record.Header{Flags: consts.FlagDel, Seq: seq}, op.Key)
+			continue
+		}
+		a := allocs[i]
+		valLen := uint32(len(op.Value))
+		copy(a.seg.GetData()[a.off:a.off+uint64(valLen)], op.Value)
+		a.seg.MarkDirty(a.off, uint64(valLen))
+		valCRC := record.ValueCRC(op.Value)
+		off += record.Write(data[off:], record.Header{
+			Flags:  consts.FlagPut,
+			ValLen: valLen,
+			ValOff: a.off,
+			ValSeg: a.seg.ID(),
+			ValCRC: valCRC,
+			Seq:    seq,
+		}, op.Key)
+		entries[i] = index.Entry{
+			SegID:  a.seg.ID(),
+			ValOff: a.off,
+			ValLen: valLen,
+			LogSeg: seg.ID(),
+			ValCRC: valCRC,
+			HasCRC: true,
+			Seq:    seq,
+		}
+	}
+	off += record.Write(data[off:], record.Header{Flags: consts.FlagCommit, Seq: db.nextSeq(), Ext: begin}, "")
+	seg.SetLogEnd(off)
+	seg.MarkDirty(start, off-start)
+
+	var events []Event
+	if db.nwatch.Load() > 0 {
+		events = make([]Event, 0, len(ops))
+	}
+	db.lifeMu.Lock()
+	defer db.lifeMu.Unlock()
+	for i, op := range ops {
+		var old index.Entry
+		var hadOld bool
+		if op.Del {
+			seg.AddTomb()
+			old, hadOld = db.dropEntry(op.Key)
+		} else {
+			old, hadOld = db.putEntry(op.Key, entries[i])
+		}
+		if hadOld {
+			db.freeEntry(old)
+		}
+		// 批内第 i 条操作记录的序列号为 begin+1+i。
+		if events == nil {
+			continue
+		}
+		if !op.Del {
+			events = append(events, Event{Key: op.Key, Op: OpSet, Seq: begin + 1 + uint64(i)})
+		} else if hadOld {
+			events = append(events, Event{Key: op.Key, Op: OpDel, Seq: begin + 1 + uint64(i)})
+		}
+	}
+	return events, nil
+}
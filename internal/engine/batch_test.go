@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"shm_master/consts"
+	"shm_master/internal/errs"
+	"shm_master/internal/record"
+	"testing"
+)
+
+func TestApplyBatchRecovers(t *testing.T) {
+	db, base := openTestDB(t)
+	if err := db.Set("inv:1:sword", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	err := db.Apply([]BatchOp{
+		{Key: "player:1", Value: []byte("hp=90")},
+		{Key: "inv:1:potion", Value: []byte("x3")},
+		{Key: "inv:1:sword", Del: true},
+	})
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	db = reopen(t, db, base)
+	if got, ok, _ := db.Get("player:1"); !ok || string(got) != "hp=90" {
+		t.Errorf("player:1 = %q ok=%v", got, ok)
+	}
+	if _, ok, _ := db.Get("inv:1:potion"); !ok {
+		t.Error("inv:1:potion missing")
+	}
+	if _, ok, _ := db.Get("inv:1:sword"); ok {
+		t.Error("inv:1:sword should be deleted")
+	}
+}
+
+func TestTornBatchIsDiscarded(t *testing.T) {
+	db, base := openTestDB(t)
+	if err := db.Set("player:1", []byte("before")); err != nil {
+		t.Fatal(err)
+	}
+	err := db.Apply([]BatchOp{
+		{Key: "player:1", Value: []byte("after")},
+		{Key: "inv:1:potion", Value: []byte("x3")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 抹掉 Commit 记录，模拟提交前崩溃。
+	seg := db.lastSeg()
+	commit := seg.LogEnd() - consts.HeaderSize
+	clear(seg.GetData()[commit:seg.LogEnd()])
+
+	db = reopen(t, db, base)
+	if got, _, _ := db.Get("player:1"); string(got) != "before" {
+		t.Errorf("player:1 = %q, want value from before the torn batch", got)
+	}
+	if _, ok, _ := db.Get("inv:1:potion"); ok {
+		t.Error("inv:1:potion from torn batch is visible")
+	}
+	// 撕裂批次之后的普通写入不能被误并入该批次。
+	if err := db.Set("player:2", []byte("later")); err != nil {
+		t.Fatal(err)
+	}
+	db = reopen(t, db, base)
+	if got, ok, _ := db.Get("player:2"); !ok || string(got) != "later" {
+		t.Errorf("player:2 = %q ok=%v", got, ok)
+	}
+	if _, ok, _ := db.Get("inv:1:potion"); ok {
+		t.Error("inv:1:potion resurrected")
+	}
+}
+
+func TestTornBatchDoesNotSwallowLaterWrites(t *testing.T) {
+	db, base := openTestDB(t)
+	err := db.Apply([]BatchOp{
+		{Key: "a", Value: []byte("1")},
+		{Key: "b", Value: []byte("2")},
+		{Key: "c", Value: []byte("3")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 抹掉第 3 个操作与 Commit，批次只剩 Begin 与前两个操作。
+	seg := db.lastSeg()
+	data := seg.GetData()
+	off := seg.LogStart()
+	for range 3 {
+		h, _, ok := record.Parse(data, off, seg.ValEnd(), seg.ID())
+		if !ok {
+			t.Fatal("batch records not found")
+		}
+		off += h.RecLen()
+	}
+	clear(data[off:seg.LogEnd()])
+
+	db = reopen(t, db, base)
+	if err := db.Set("x", []byte("later")); err != nil {
+		t.Fatal(err)
+	}
+	db = reopen(t, db, base)
+	if got, ok, _ := db.Get("x"); !ok || string(got) != "later" {
+		t.Errorf("x = %q ok=%v, want write after torn batch", got, ok)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		if _, ok, _ := db.Get(k); ok {
+			t.Errorf("%s from torn batch is visible", k)
+		}
+	}
+}
+
+// 空段也放不下的批次直接拒绝，不追加段。
+func TestApplyRejectsBatchThatNeverFits(t *testing.T) {
+	db, _ := openTestDB(t)
+	var ops []BatchOp
+	for i := 0; i < testSegSize/1000; i++ {
+		ops = append(ops, BatchOp{Key: fmt.Sprintf("k%d", i), Value: make([]byte, 1000)})
+	}
+	if err := db.Apply(ops); !errors.Is(err, errs.ErrBadArgument) {
+		t.Errorf("oversized batch: %v", err)
+	}
+	if err := db.Apply([]BatchOp{{Key: "big", Value: make([]byte, 2*db.chunkSize()+1)}}); !errors.Is(err, errs.ErrBadArgument) {
+		t.Errorf("chunk-sized value in batch: %v", err)
+	}
+	if n := len(db.segMgr.Segments()); n != 1 {
+		t.Errorf("%d segments after rejected batches, want 1", n)
+	}
+	if err := db.Apply(ops[:len(ops)/2]); err != nil {
+		t.Errorf("half batch: %v", err)
+	}
+}
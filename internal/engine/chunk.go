@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"shm_master/consts"
+	"shm_master/internal/errs"
+	"shm_master/internal/index"
+	"shm_master/internal/record"
+)
+
+// chunkSize 返回分块大小：单段可用空间的四分之一，按 Align 向下取整。
+// 超过两块大小的 value 会被分块存储，其余 value 仍整体放在一个块里。
+func (db *DB) chunkSize() int {
+	return int((db.segSize-consts.SuperSize)/4) &^ (consts.Align - 1)
+}
+
+// putValue 写入 value，过大时自动分块。分块清单须能放进一个分块，否则返回 ErrBadArgument，
+// 此时不分配任何空间。调用方需持有 writeMu。
+func (db *DB) putValue(key string, value []byte, expireAt int64) error {
+	cs := db.chunkSize()
+	if len(value) <= 2*cs {
+		return db.writePut(key, value, putMeta{valCRC: record.ValueCRC(value), expireAt: expireAt})
+	}
+	n := (len(value) + cs - 1) / cs
+	if record.ChunksLen(n) > cs {
+		return errs.ErrBadArgument
+	}
+	parts := make([][]byte, 0, n)
+	for len(value) > 0 {
+		n := min(len(value), cs)
+		parts = append(parts, value[:n])
+		value = value[n:]
+	}
+	return db.writeChunks(key, parts, nil, expireAt)
+}
+
+// writeChunks 把各分块写入任意段的空闲块，再在活跃段写一条带分块清单的 Put 记录。
+// crcs 为 nil 时重新计算各块校验和；压缩搬迁时沿用原校验和。记录写入前失败会归还已分配的块。
+// 调用方须保证清单能放进一个分块。
+func (db *DB) writeChunks(key string, parts [][]byte, crcs []uint32, expireAt int64) error {
+	recTotal := recSize(key)
+	chunks := make([]record.Chunk, 0, len(parts))
+	undo := func() {
+		for _, c := range chunks {
+			if seg := db.segMgr.Seg(c.Seg); seg != nil {
+				seg.FreeBlock(c.Off, c.Len)
+			}
+		}
+	}
+	for i, p := range parts {
+		n := uint32(len(p))
+		vseg, off, ok := db.segMgr.Alloc(n, recTotal)
+		if !ok {
+			_, err := db.appendSeg()
+			if err != nil {
+				undo()
+				return err
+			}
+			if vseg, off, ok = db.segMgr.Alloc(n, recTotal); !ok {
+				undo()
+				return errs.ErrNoSpace
+			}
+		}
+		copy(vseg.GetData()[off:off+uint64(n)], p)
+		vseg.MarkDirty(off, uint64(n))
+		var crc uint32
+		if crcs != nil {
+			crc = crcs[i]
+		} else {
+			crc = record.ValueCRC(p)
+		}
+		chunks = append(chunks, record.Chunk{Seg: vseg.ID(), Off: off, Len: n, CRC: crc})
+	}
+	man := record.EncodeChunks(chunks)
+	if err := db.writePut(key, man, putMeta{valCRC: record.ValueCRC(man), expireAt: expireAt, chunked: true}); err != nil {
+		undo()
+		return err
+	}
+	return nil
+}
+
+// chunksOf 解析分块 value 的清单；e 不是分块 value、清单校验失败或分块越界时返回 nil。
+// 调用方需持有 writeMu 或 lifeMu 读锁。
+func (db *DB) chunksOf(e index.Entry) []record.Chunk {
+	if !e.Chunked {
+		return nil
+	}
+	seg := db.segMgr.Mapped(e.SegID)
+	if seg == nil {
+		return nil
+	}
+	end := e.ValOff + uint64(e.ValLen)
+	if end > uint64(seg.DataLen()) {
+		return nil
+	}
+	b := seg.GetData()[e.ValOff:end]
+	if record.ValueCRC(b) != e.ValCRC {
+		return nil
+	}
+	chunks, _, ok := record.DecodeChunks(b)
+	if !ok {
+		return nil
+	}
+	for _, c := range chunks {
+		cs := db.segMgr.Mapped(c.Seg)
+		if cs == nil || c.Len == 0 || c.Off+uint64(c.Len) > uint64(cs.DataLen()) {
+			return nil
+		}
+	}
+	return chunks
+}
+
+// chunkIn 报告分块 value e 是否有分块位于段 id。
+func (db *DB) chunkIn(e index.Entry, id uint32) bool {
+	for _, c := range db.chunksOf(e) {
+		if c.Seg == id {
+			return true
+		}
+	}
+	return false
+}
+
+// chunkData 返回分块 c 在映射中的数据。调用方需保证 chunksOf 已校验过 c。
+func (db *DB) chunkData(c record.Chunk) []byte {
+	return db.segMgr.Mapped(c.Seg).GetData()[c.Off : c.Off+uint64(c.Len)]
+}
+
+// assemble 把分块 value 拼接成一份拷贝，开启 verifyOnGet 或 e 未经校验时逐块校验。调用方需持有 lifeMu 读锁。
+func (db *DB) assemble(key string, e index.Entry) ([]byte, bool, error) {
+	chunks := db.chunksOf(e)
+	if chunks == nil {
+		return nil, false, corruptKey(key)
+	}
+	var total int
+	for _, c := range chunks {
+		total += int(c.Len)
+	}
+	verify := e.Unverified || db.verifyOnGet.Load()
+	out := make([]byte, 0, total)
+	for _, c := range chunks {
+		b := db.chunkData(c)
+		if verify && record.ValueCRC(b) != c.CRC {
+			return nil, false, corruptKey(key)
+		}
+		out = append(out, b...)
+	}
+	return out, true, nil
+}
+
+// rewrite 把 e 的 value 连同原校验和重写为一条新记录，过期时间设为 expireAt。调用方需持有 writeMu。
+// 清单已损坏的分块 value 按原样搬迁清单，保持损坏状态；重写不校验数据，未校验标记随之保留。
+func (db *DB) rewrite(key string, e index.Entry, expireAt int64) error {
+	if err := db.rewriteValue(key, e, expireAt); err != nil || !e.Unverified {
+		return err
+	}
+	ne, _ := db.idx.Get(key)
+	ne.Unverified = true
+	db.idx.Set(key, ne)
+	return nil
+}
+
+func (db *DB) rewriteValue(key string, e index.Entry, expireAt int64) error {
+	if e.Chunked {
+		if chunks := db.chunksOf(e); chunks != nil {
+			parts := make([][]byte, len(chunks))
+			crcs := make([]uint32, len(chunks))
+			for i, c := range chunks {
+				parts[i], crcs[i] = db.chunkData(c), c.CRC
+			}
+			return db.writeChunks(key, parts, crcs, expireAt)
+		}
+	}
+	seg := db.segMgr.Seg(e.SegID)
+	if seg == nil || seg.GetData() == nil {
+		return errs.ErrClosed
+	}
+	data := seg.GetData()
+	end := e.ValOff + uint64(e.ValLen)
+	if end > uint64(len(data)) {
+		return errs.ErrCorrupt
+	}
+	val := data[e.ValOff:end]
+	crc := e.ValCRC
+	if !e.HasCRC {
+		crc = record.ValueCRC(val)
+	}
+	return db.writePut(key, val, putMeta{valCRC: crc, expireAt: expireAt, chunked: e.Chunked})
+}
@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"path/filepath"
+	"shm_master/internal/errs"
+	"shm_master/internal/record"
+	"testing"
+)
+
+func TestChunkedValue(t *testing.T) {
+	db, base := openTestDB(t)
+	big := make([]byte, 3*testSegSize+1234)
+	for i := range big {
+		big[i] = byte(rand.IntN(256))
+	}
+	if err := db.Set("replay", big); err != nil {
+		t.Fatalf("Set big: %v", err)
+	}
+	if err := db.Set("small", []byte("tiny")); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, err := db.Get("replay"); err != nil || !ok || !bytes.Equal(got, big) {
+		t.Fatalf("Get big: ok=%v err=%v equal=%v", ok, err, bytes.Equal(got, big))
+	}
+
+	db = reopen(t, db, base)
+	r, ok, err := db.NewReader("replay")
+	if err != nil || !ok {
+		t.Fatalf("NewReader: ok=%v err=%v", ok, err)
+	}
+	if r.Size() != int64(len(big)) {
+		t.Errorf("Size = %d", r.Size())
+	}
+	streamed, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(streamed, big) {
+		t.Fatalf("stream: err=%v equal=%v", err, bytes.Equal(streamed, big))
+	}
+
+	// 覆盖后压缩，分块所在的段都应被回收。
+	if err := db.Set("replay", []byte("short now")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.appendSeg(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Compact(DefaultCompactRatio); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(db.segMgr.Segments()); n > 2 {
+		t.Errorf("%d segments left after compacting dead chunks", n)
+	}
+	db = reopen(t, db, base)
+	for k, want := range map[string]string{"replay": "short now", "small": "tiny"} {
+		if got, ok, _ := db.Get(k); !ok || string(got) != want {
+			t.Errorf("%s = %q ok=%v", k, got, ok)
+		}
+	}
+}
+
+func TestChunkedValueCorruption(t *testing.T) {
+	db, base := openTestDB(t)
+	big := bytes.Repeat([]byte("0123456789abcdef"), testSegSize/8)
+	if err := db.Set("blob", big); err != nil {
+		t.Fatal(err)
+	}
+	e, _ := db.idx.Get("blob")
+	c := db.chunksOf(e)[1]
+	db.chunkData(c)[7] ^= 0xff
+
+	db = reopen(t, db, base)
+	if _, _, err := db.Get("blob"); !errors.Is(err, errs.ErrCorrupt) {
+		t.Errorf("Get corrupted chunk: want ErrCorrupt, got %v", err)
+	}
+}
+
+// 分块清单放不进一个分块的 value 直接拒绝，不追加段也不占用空闲块。
+func TestChunkManifestTooLarge(t *testing.T) {
+	db, err := OpenWithOptions(filepath.Join(t.TempDir(), "kv.data"), Options{SegSize: MinSegSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cs := db.chunkSize()
+	n := (cs-record.ChunksLen(0))/(record.ChunksLen(1)-record.ChunksLen(0)) + 1
+	if err := db.Set("big", make([]byte, n*cs)); !errors.Is(err, errs.ErrBadArgument) {
+		t.Fatalf("Set with %d chunks: %v", n, err)
+	}
+	if got := len(db.segMgr.Segments()); got != 1 {
+		t.Errorf("%d segments after rejected Set, want 1", got)
+	}
+	if err := db.Set("big", make([]byte, (n-1)*cs)); err != nil {
+		t.Fatalf("Set with %d chunks: %v", n-1, err)
+	}
+}
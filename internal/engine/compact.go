@@ -0,0 +1,233 @@
+package engine
+
+import (
+	"shm_master/consts"
+	"shm_master/internal/errs"
+	"shm_master/internal/index"
+	"shm_master/internal/record"
+	"time"
+)
+
+// DefaultCompactRatio 默认压缩阈值：死字节占段大小的比例。
+const DefaultCompactRatio = 0.5
+
+// Compact 对死字节比例不低于 minRatio 的已封存段执行一次在线压缩：
+// 把段内仍存活的 key 重写到活跃段，再退役该段。返回退役的段数。
+// 压缩按 key 逐个持有 writeMu，期间读写可以并发进行。
+func (db *DB) Compact(minRatio float64) (int, error) {
+	if err := db.writable(); err != nil {
+		return 0, err
+	}
+	if minRatio <= 0 || minRatio > 1 {
+		return 0, errs.ErrBadArgument
+	}
+	n := 0
+	for _, id := range db.compactCandidates(minRatio) {
+		retired, err := db.compactSeg(id)
+		if err != nil {
+			return n, err
+		}
+		if retired {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// compactCandidates 选出除活跃段外、死字节比例达到阈值的段。
+func (db *DB) compactCandidates(minRatio float64) []uint32 {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	last := db.lastSeg()
+	if last == nil {
+		return nil
+	}
+	var ids []uint32
+	for _, seg := range db.segMgr.Segments() {
+		if seg == last || seg.DataLen() == 0 {
+			continue
+		}
+		if float64(seg.DeadBytes())/float64(seg.DataLen()) >= minRatio {
+			ids = append(ids, seg.ID())
+		}
+	}
+	return ids
+}
+
+// compactSeg 搬迁段 id 中的存活数据与必要的墓碑，然后退役该段。
+// 存活数据包括 value 或分块位于该段的 key，以及记录写在该段 log 中的 key。
+// 返回该段是否已退役；搬迁后段内仍有存活数据时不退役，留待下一轮。
+func (db *DB) compactSeg(id uint32) (retired bool, err error) {
+	db.writeMu.Lock()
+	if seg := db.segMgr.Seg(id); seg != nil {
+		seg.SetRetiring(true)
+	}
+	db.writeMu.Unlock()
+
+	// 分块 value 的分块可能落在任意段，统一交给 moveKey 在 writeMu 下确认。
+	var keys []string
+	db.idx.Range(func(key string, e index.Entry) bool {
+		if e.SegID == id || e.LogSeg == id || e.Chunked {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	err = db.carryTombstones(id)
+	for _, key := range keys {
+		if err != nil {
+			break
+		}
+		err = db.moveKey(key, id)
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	seg := db.segMgr.Seg(id)
+	if seg == nil {
+		return false, err
+	}
+	if err != nil || seg.Live() != 0 {
+		// 搬迁失败，或期间又有 key 落在该段（理论上不会发生），留待下一轮。
+		seg.SetRetiring(false)
+		return false, err
+	}
+	// 搬迁后的记录须先落盘，再在 manifest 中退役旧段并删除其文件；否则掉电会丢失压缩前已落盘的数据。
+	if err := db.syncLocked(); err != nil {
+		seg.SetRetiring(false)
+		return false, err
+	}
+	// 等待在途的 hint 写完，否则它可能在段退役、hint 被删除之后才落地。
+	db.hintWG.Wait()
+	db.lifeMu.Lock()
+	defer db.lifeMu.Unlock()
+	if err := db.segMgr.Retire(id); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// moveKey 若 key 仍引用段 id，则把其记录与 value 重写到活跃段。
+func (db *DB) moveKey(key string, id uint32) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	e, ok := db.idx.Get(key)
+	if !ok || (e.SegID != id && e.LogSeg != id && !db.chunkIn(e, id)) {
+		return nil
+	}
+	if e.Expired(time.Now().UnixNano()) {
+		// 已过期的 key 不再搬迁，直接写墓碑。
+		if err := db.delLocked(key); err != nil {
+			return err
+		}
+		db.notify(OpDel, key, db.seq)
+		return nil
+	}
+	if err := db.rewrite(key, e, e.ExpireAt); err != nil {
+		return err
+	}
+	if e.Corrupt {
+		// 搬迁后仍保留损坏标记。
+		ne, _ := db.idx.Get(key)
+		ne.Corrupt = true
+		db.idx.Set(key, ne)
+	}
+	return nil
+}
+
+// carryTombstones 把段 id 中仍然有效的墓碑重写到活跃段：
+// 只要还有更早的存活段，丢弃墓碑就可能让旧值在 Recover 时复活。
+func (db *DB) carryTombstones(id uint32) error {
+	db.writeMu.Lock()
+	seg := db.segMgr.Seg(id)
+	if seg == nil || seg.GetData() == nil {
+		db.writeMu.Unlock()
+		return nil
+	}
+	hasOlder := false
+	for _, s := range db.segMgr.Segments() {
+		if s.ID() < id {
+			hasOlder = true
+			break
+		}
+	}
+	var dels []string
+	if hasOlder {
+		seen := make(map[string]struct{})
+		scanLog(seg, func(h record.Header, key []byte) bool {
+			if h.Op() == consts.FlagDel {
+				if _, ok := seen[string(key)]; !ok {
+					seen[string(key)] = struct{}{}
+					dels = append(dels, string(key))
+				}
+			}
+			return true
+		})
+	}
+	db.writeMu.Unlock()
+
+	for _, key := range dels {
+		db.writeMu.Lock()
+		var err error
+		if _, ok := db.idx.Get(key); !ok {
+			err = db.delLocked(key)
+		}
+		db.writeMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReleaseRetired 解除已退役段的映射并关闭其文件，被删除的段文件这时才真正归还磁盘空间；返回释放的段数。
+// 退役段默认保持映射到 Close，以保证此前 Get、Scan 返回的零拷贝切片仍可读取；调用方须确认不再持有
+// 指向这些段的切片，之后再访问会使进程崩溃。有快照或 ValueReader 打开时不释放任何段，返回 0。
+func (db *DB) ReleaseRetired() (int, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.lifeMu.Lock()
+	defer db.lifeMu.Unlock()
+	if db.segMgr.Last() == nil {
+		return 0, errs.ErrClosed
+	}
+	// 快照只在持有 writeMu 时打开，此处读到 0 即不会再有新快照引用退役段。
+	if db.nsnap.Load() > 0 {
+		return 0, nil
+	}
+	return db.segMgr.ReleaseRetired()
+}
+
+// StartCompactor 启动后台压缩：每隔 interval 以 minRatio 执行一次 Compact，Close 时停止。
+// 重复调用只会保留第一次启动的压缩协程。被退役的段要等 ReleaseRetired 或 Close 才归还磁盘空间。
+func (db *DB) StartCompactor(interval time.Duration, minRatio float64) error {
+	if err := db.writable(); err != nil {
+		return err
+	}
+	if interval <= 0 || minRatio <= 0 || minRatio > 1 {
+		return errs.ErrBadArgument
+	}
+	db.bgMu.Lock()
+	defer db.bgMu.Unlock()
+	if db.stopCompact != nil {
+		return nil
+	}
+	stop := make(chan struct{})
+	db.stopCompact = stop
+	db.bgWG.Add(1)
+	go func() {
+		defer db.bgWG.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				if _, err := db.Compact(minRatio); err != nil {
+					db.log.Warn("db: background compaction failed", "base", db.base, "err", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSegSize = 64 << 10
+
+func openTestDB(t *testing.T) (*DB, string) {
+	t.Helper()
+	base := filepath.Join(t.TempDir(), "kv.data")
+	db, err := Open(base, testSegSize)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db, base
+}
+
+func TestCompactRetiresDeadSegments(t *testing.T) {
+	db, base := openTestDB(t)
+	var pinned []byte
+	for round := 0; round < 5; round++ {
+		// 每轮换一个档位，避免活跃段的 freelist 直接复用旧块。
+		val := bytes.Repeat([]byte{byte(round)}, 1000+round*16)
+		for i := 0; i < 50; i++ {
+			if err := db.Set(fmt.Sprintf("k%d", i), val); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+		}
+		if round == 0 {
+			b, ok, err := db.Get("k0")
+			if err != nil || !ok {
+				t.Fatalf("Get k0: ok=%v err=%v", ok, err)
+			}
+			pinned = b
+		}
+	}
+	if len(db.segMgr.Segments()) < 3 {
+		t.Fatalf("expected several segments, got %d", len(db.segMgr.Segments()))
+	}
+	want := bytes.Repeat([]byte{0}, 1000)
+
+	n, err := db.Compact(DefaultCompactRatio)
+	if err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if n == 0 {
+		t.Fatal("expected at least one segment retired")
+	}
+	if _, err := os.Stat(base + ".000"); !os.IsNotExist(err) {
+		t.Errorf("base.000 should be removed, stat err=%v", err)
+	}
+	if !bytes.Equal(pinned, want) {
+		t.Error("zero-copy slice changed after compaction")
+	}
+	for i := 0; i < 50; i++ {
+		got, ok, err := db.Get(fmt.Sprintf("k%d", i))
+		if err != nil || !ok || got[0] != 4 || len(got) != 1000+4*16 {
+			t.Fatalf("Get k%d after compact: ok=%v err=%v", i, ok, err)
+		}
+	}
+}
+
+// 搬迁后段内仍有存活字节时不退役，Compact 也不计入。
+func TestCompactCountsOnlyRetired(t *testing.T) {
+	db, _ := openTestDB(t)
+	for round := 0; round < 4; round++ {
+		val := bytes.Repeat([]byte{byte(round)}, 1000+round*16)
+		for i := 0; i < 50; i++ {
+			if err := db.Set(fmt.Sprintf("k%d", i), val); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	first := db.segMgr.Segments()[0]
+	first.AddLive(1) // 模拟搬迁期间又落入该段、无法搬走的数据
+	n, err := db.Compact(DefaultCompactRatio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.segMgr.Seg(first.ID()) == nil || first.Retiring() {
+		t.Fatal("segment with live bytes was retired or left retiring")
+	}
+	if got, _ := db.segMgr.Retired(); n != got {
+		t.Errorf("Compact reported %d retired, %d segments actually retired", n, got)
+	}
+}
+
+func TestReleaseRetired(t *testing.T) {
+	db, _ := openTestDB(t)
+	for round := 0; round < 4; round++ {
+		val := bytes.Repeat([]byte{byte(round)}, 1000+round*16)
+		for i := 0; i < 50; i++ {
+			if err := db.Set(fmt.Sprintf("k%d", i), val); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	n, err := db.Compact(DefaultCompactRatio)
+	if err != nil || n == 0 {
+		t.Fatalf("Compact: n=%d err=%v", n, err)
+	}
+	st, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Retired != n || st.RetiredSize != uint64(n)*testSegSize {
+		t.Errorf("Stats: retired %d (%d bytes), want %d", st.Retired, st.RetiredSize, n)
+	}
+
+	snap := db.Snapshot()
+	if got, err := db.ReleaseRetired(); got != 0 || err != nil {
+		t.Errorf("ReleaseRetired with open snapshot: %d, %v", got, err)
+	}
+	snap.Release()
+	if got, err := db.ReleaseRetired(); got != n || err != nil {
+		t.Errorf("ReleaseRetired: %d, %v, want %d", got, err, n)
+	}
+	if st, _ := db.Stats(); st.Retired != 0 || st.RetiredSize != 0 {
+		t.Errorf("Stats after release: retired %d (%d bytes)", st.Retired, st.RetiredSize)
+	}
+	for i := 0; i < 50; i++ {
+		got, ok, err := db.Get(fmt.Sprintf("k%d", i))
+		if err != nil || !ok || got[0] != 3 {
+			t.Fatalf("Get k%d after release: ok=%v err=%v", i, ok, err)
+		}
+	}
+}
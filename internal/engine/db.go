@@ -1,10 +1,13 @@
 package engine
 
 import (
-	"shm_master/consts"
+	"log/slog"
+	"shm_master/internal/errs"
+	"shm_master/internal/fs"
 	"shm_master/internal/index"
 	"shm_master/internal/segment"
 	"sync"
+	"sync/atomic"
 )
 
 type DB struct {
@@ -14,8 +17,40 @@ type DB struct {
 	base    string
 	segSize int64
 
-	segMgr *segment.Manager
-	idx    index.Index
+	segMgr     *segment.Manager
+	idx        index.Index
+	seq        uint64 // 最近分配的记录序列号，受 writeMu 保护
+	readOnly   bool
+	syncPolicy SyncPolicy // 打开后不再改变
+	lock       *fs.Lock   // 写者持有的 base.LOCK，只读时为 nil
+	log        *slog.Logger
+	metrics    *Metrics // nil 表示不采集
+	// recoverWorkers Recover 并行解析段的协程数，0 取 GOMAXPROCS。
+	recoverWorkers int
+	recovery       *RecoveryReport // 最近一次 Recover 的结果，受 writeMu 保护
+	hintWG         sync.WaitGroup  // 后台写 hint 的协程，只在持有 writeMu 时 Add
+
+	// 跟随模式的解析进度：tailSeg 为上次解析到的最后一段，tail 为其段末尚未提交的批次。受 writeMu 保护。
+	tailSeg uint32
+	tail    *pendingBatch
+
+	verifyOnGet atomic.Bool
+
+	snapMu   sync.RWMutex
+	snaps    map[*Snapshot]struct{} // 受 snapMu 保护
+	nsnap    atomic.Int32           // 打开的快照数，只在持有 writeMu 时增加
+	deferred []deferredFree         // 受 writeMu 保护
+
+	watchMu  sync.RWMutex
+	watchers map[*Watcher]struct{} // 受 watchMu 保护
+	nwatch   atomic.Int32          // 订阅数，供 notify 快速跳过
+
+	bgMu        sync.Mutex
+	bgWG        sync.WaitGroup
+	stopCompact chan struct{}
+	stopReap    chan struct{}
+	stopFollow  chan struct{}
+	stopSync    chan struct{}
 }
 
 func NewDB(base string, segSize int64, shardN int) *DB {
@@ -24,30 +59,133 @@ func NewDB(base string, segSize int64, shardN int) *DB {
 		segSize: segSize,
 		segMgr:  segment.NewManager(base, segSize),
 		idx:     index.NewSharded(shardN),
+		log:     slog.New(slog.DiscardHandler),
 	}
 }
 
-// Open 打开或创建 DB
+// Open 以默认配置打开或创建 DB。
 func Open(base string, segSize int64) (*DB, error) {
-	db := NewDB(base, segSize, consts.ShardSize)
-	if err := db.segMgr.OpenBase(); err != nil {
+	return OpenWithOptions(base, Options{SegSize: segSize})
+}
+
+// OpenWithOptions 按 opts 打开或创建 DB，opts 非法时返回 ErrBadArgument。
+func OpenWithOptions(base string, opts Options) (*DB, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
 		return nil, err
 	}
-	if err := db.segMgr.EnsureOne(); err != nil {
+	db := NewDB(base, opts.SegSize, opts.Shards)
+	if opts.Index == IndexOrdered {
+		db.idx = index.NewSkipList()
+	}
+	db.syncPolicy = opts.Sync
+	db.log = opts.Logger
+	db.metrics = opts.Metrics
+	if opts.ReadOnly {
+		db.readOnly = true
+		db.segMgr = segment.NewReadOnlyManager(base, opts.SegSize)
+		// 写者之后可能复用块，读到的旧索引项需要靠校验和识别。
+		db.verifyOnGet.Store(true)
+	} else {
+		lock, err := fs.LockFile(fs.LockPath(base), opts.FileMode)
+		if err != nil {
+			return nil, err
+		}
+		db.lock = lock
+	}
+	db.segMgr.SetFileMode(opts.FileMode)
+	db.segMgr.SetMaxSegments(opts.maxSegs())
+	if err := db.segMgr.OpenBase(); err != nil {
+		_ = db.Close()
 		return nil, err
 	}
-	if err := db.Recover(); err != nil {
+	if orphans := db.segMgr.Orphans(); len(orphans) > 0 {
+		db.log.Warn("db: segment files not listed in manifest were ignored", "base", base, "files", orphans)
+	}
+	if err := db.segMgr.EnsureOne(); err != nil {
 		_ = db.Close()
 		return nil, err
 	}
+	noHint := db.recoverSegs()
+	if opts.StrictRecovery {
+		if err := db.recovery.strictErr(); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	// 严格模式检查通过后才补写 hint，失败时不改动任何文件。
+	db.writeHints(noHint)
+	if opts.Sync == SyncInterval {
+		db.startSyncer(opts.SyncInterval)
+	}
+	if opts.FollowInterval > 0 {
+		if err := db.StartFollow(opts.FollowInterval); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
 	return db, nil
 }
 
-// Close 关闭所有段并清空索引。
+// OpenReadOnly 只读打开已有的 DB，可与另一个进程中的写者并存。
+func OpenReadOnly(base string, segSize int64) (*DB, error) {
+	return OpenWithOptions(base, Options{SegSize: segSize, ReadOnly: true})
+}
+
+// Close 停止后台任务，关闭所有段并释放写者锁。
 func (db *DB) Close() error {
+	db.stopBackground()
 	db.writeMu.Lock()
 	defer db.writeMu.Unlock()
+	db.hintWG.Wait()
 	db.lifeMu.Lock()
 	defer db.lifeMu.Unlock()
-	return db.segMgr.Close()
+	err := db.segMgr.Close()
+	db.closeWatchers()
+	if uerr := db.lock.Unlock(); err == nil {
+		err = uerr
+	}
+	db.lock = nil
+	return err
+}
+
+// writable 只读打开时返回 ErrReadOnly。
+func (db *DB) writable() error {
+	if db.readOnly {
+		return errs.ErrReadOnly
+	}
+	return nil
+}
+
+// ReadOnly 报告 DB 是否为只读打开。
+func (db *DB) ReadOnly() bool { return db.readOnly }
+
+// stopBackground 通知并等待后台协程退出。
+func (db *DB) stopBackground() {
+	db.bgMu.Lock()
+	if db.stopCompact != nil {
+		close(db.stopCompact)
+		db.stopCompact = nil
+	}
+	if db.stopReap != nil {
+		close(db.stopReap)
+		db.stopReap = nil
+	}
+	if db.stopFollow != nil {
+		close(db.stopFollow)
+		db.stopFollow = nil
+	}
+	if db.stopSync != nil {
+		close(db.stopSync)
+		db.stopSync = nil
+	}
+	db.bgMu.Unlock()
+	db.bgWG.Wait()
+}
+
+// Orphans 返回目录中未被 manifest 登记的段文件。
+func (db *DB) Orphans() []string {
+	db.lifeMu.RLock()
+	defer db.lifeMu.RUnlock()
+	return append([]string(nil), db.segMgr.Orphans()...)
 }
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"shm_master/internal/errs"
+	"shm_master/internal/segment"
+	"time"
+)
+
+// Refresh 只读打开时追上写者：重新读取 manifest 打开新段，再从上次停下的位置继续解析
+// 新追加的记录并更新索引。写者打开时索引总是最新的，直接返回 nil。
+func (db *DB) Refresh() error {
+	if !db.readOnly {
+		return nil
+	}
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.lifeMu.Lock()
+	if db.segMgr.Last() == nil {
+		db.lifeMu.Unlock()
+		return errs.ErrClosed
+	}
+	_, err := db.segMgr.Refresh()
+	db.lifeMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// 上次的最后一段即使已被退役也仍保持映射，先把它读完，再依次解析更新的段。
+	var segs []*segment.Segment
+	if seg := db.segMgr.Mapped(db.tailSeg); seg != nil {
+		segs = append(segs, seg)
+	}
+	for _, seg := range db.segMgr.Segments() {
+		if seg.ID() > db.tailSeg {
+			segs = append(segs, seg)
+		}
+	}
+	b := db.tail
+	for i, seg := range segs {
+		b = db.replay(seg, b)
+		if i < len(segs)-1 {
+			db.dropBatch(b)
+			b = nil
+		}
+		db.tail, db.tailSeg = b, seg.ID()
+	}
+	return nil
+}
+
+// StartFollow 只读打开时启动后台跟随：每隔 interval 执行一次 Refresh，Close 时停止。
+// 重复调用只会保留第一次启动的跟随协程。
+func (db *DB) StartFollow(interval time.Duration) error {
+	if !db.readOnly || interval <= 0 {
+		return errs.ErrBadArgument
+	}
+	db.bgMu.Lock()
+	defer db.bgMu.Unlock()
+	if db.stopFollow != nil {
+		return nil
+	}
+	stop := make(chan struct{})
+	db.stopFollow = stop
+	db.bgWG.Add(1)
+	go func() {
+		defer db.bgWG.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				if err := db.Refresh(); err != nil {
+					db.log.Warn("db: background refresh failed", "base", db.base, "err", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
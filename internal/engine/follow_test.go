@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"shm_master/internal/errs"
+	"testing"
+	"time"
+)
+
+func TestFollowerRefresh(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "kv.data")
+	db, err := Open(base, testSegSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenWithOptions(base, Options{SegSize: testSegSize, FollowInterval: time.Millisecond}); !errors.Is(err, errs.ErrBadArgument) {
+		t.Fatalf("FollowInterval without ReadOnly: %v", err)
+	}
+	ro, err := OpenReadOnly(base, testSegSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+
+	if err := db.Set("b", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Del("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Apply([]BatchOp{{Key: "c", Value: []byte("3")}, {Key: "d", Value: []byte("4")}}); err != nil {
+		t.Fatal(err)
+	}
+	// 写满若干段，迫使写者追加新段。
+	val := make([]byte, 1024)
+	for i := 0; i < 200; i++ {
+		if err := db.Set(fmt.Sprintf("fill%03d", i), val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok, _ := ro.Get("b"); ok {
+		t.Fatal("follower saw b before Refresh")
+	}
+	if err := ro.Refresh(); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	want := map[string]string{"b": "2", "c": "3", "d": "4"}
+	for k, v := range want {
+		if got, ok, err := ro.Get(k); err != nil || !ok || string(got) != v {
+			t.Errorf("ro.Get(%q) = %q ok=%v err=%v", k, got, ok, err)
+		}
+	}
+	if _, ok, _ := ro.Get("a"); ok {
+		t.Error("deleted key a still visible")
+	}
+	if got, ok, err := ro.Get("fill199"); err != nil || !ok || len(got) != len(val) {
+		t.Errorf("ro.Get(fill199) len=%d ok=%v err=%v", len(got), ok, err)
+	}
+
+	f, err := OpenWithOptions(base, Options{SegSize: testSegSize, ReadOnly: true, FollowInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := db.Set("e", []byte("5")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if got, ok, _ := f.Get("e"); ok && string(got) == "5" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background follow did not pick up e")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"fmt"
+	"shm_master/internal/errs"
+	"shm_master/internal/index"
+	"shm_master/internal/record"
+	"shm_master/internal/segment"
+	"sort"
+)
+
+// ProblemKind Check 发现的不一致类型。
+type ProblemKind string
+
+const (
+	// ProblemCRC value 或分块的校验和不符，或 Recover 时已被标记为损坏。
+	ProblemCRC ProblemKind = "crc"
+	// ProblemOverlap 两个存活 value 块互相重叠。
+	ProblemOverlap ProblemKind = "overlap"
+	// ProblemInLog 存活 value 块落在所在段的 log 区内。
+	ProblemInLog ProblemKind = "in-log"
+	// ProblemOutOfRange 索引项指向不存在的段或超出段的 DataLen。
+	ProblemOutOfRange ProblemKind = "out-of-range"
+	// ProblemOrphan 目录中有未被 manifest 登记的段文件。
+	ProblemOrphan ProblemKind = "orphan"
+	// ProblemFreeLive freelist 中的空闲块与存活 value 重叠，之后的写入会覆盖它。
+	ProblemFreeLive ProblemKind = "free-live"
+	// ProblemTruncated 段的 log 在非法记录处停止解析，其后的内容被丢弃。
+	ProblemTruncated ProblemKind = "truncated-log"
+)
+
+// Problem 一处不一致。Key 为涉及的 key，段级问题为空。
+type Problem struct {
+	Kind   ProblemKind `json:"kind"`
+	Seg    uint32      `json:"seg"`
+	Off    uint64      `json:"off"`
+	Len    uint64      `json:"len"`
+	Key    string      `json:"key,omitempty"`
+	Detail string      `json:"detail,omitempty"`
+}
+
+func (p Problem) String() string {
+	s := fmt.Sprintf("%s seg %d off %d len %d", p.Kind, p.Seg, p.Off, p.Len)
+	if p.Key != "" {
+		s += fmt.Sprintf(" key %q", p.Key)
+	}
+	if p.Detail != "" {
+		s += ": " + p.Detail
+	}
+	return s
+}
+
+// CheckReport Check 的结果。
+type CheckReport struct {
+	Segments int       `json:"segments"`
+	Keys     int       `json:"keys"`
+	Problems []Problem `json:"problems"`
+}
+
+// OK 报告是否没有发现任何不一致。
+func (r *CheckReport) OK() bool { return len(r.Problems) == 0 }
+
+// extent 段内一段被占用的字节，key 为空时是 freelist 中的空闲块。
+type extent struct {
+	off, end uint64
+	key      string
+	what     string // value、chunks 或 chunk
+}
+
+// Check 校验 Recover 容忍而不报告的各种不一致：校验和、存活 value 之间及与 log 区、freelist 的重叠、
+// 越界的索引项、孤儿段文件以及被截断的 log。只读取内存状态与映射，不修改任何数据；
+// 通常对只读打开的 DB 调用，其间会阻塞写入。
+func (db *DB) Check() (*CheckReport, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.lifeMu.RLock()
+	defer db.lifeMu.RUnlock()
+	if db.segMgr.Last() == nil {
+		return nil, errs.ErrClosed
+	}
+	segs := db.segMgr.Segments()
+	rep := &CheckReport{Segments: len(segs)}
+	for _, name := range db.segMgr.Orphans() {
+		rep.Problems = append(rep.Problems, Problem{Kind: ProblemOrphan, Detail: name})
+	}
+	for _, seg := range segs {
+		if n := truncatedBytes(seg, seg.LogEnd(), seg.ValEnd()); n > 0 {
+			rep.Problems = append(rep.Problems, Problem{Kind: ProblemTruncated, Seg: seg.ID(), Off: seg.LogEnd(), Len: n})
+		}
+	}
+
+	live := make(map[uint32][]extent)
+	db.idx.Range(func(key string, e index.Entry) bool {
+		rep.Keys++
+		rep.Problems = append(rep.Problems, db.checkEntry(key, e, live)...)
+		return true
+	})
+	for _, seg := range segs {
+		rep.Problems = append(rep.Problems, checkExtents(seg, live[seg.ID()])...)
+	}
+	return rep, nil
+}
+
+// checkEntry 校验 key 的索引项，并把它占用的块记入 live。
+func (db *DB) checkEntry(key string, e index.Entry, live map[uint32][]extent) []Problem {
+	var out []Problem
+	bad := func(kind ProblemKind, seg uint32, off, n uint64, format string, args ...any) {
+		out = append(out, Problem{Kind: kind, Seg: seg, Off: off, Len: n, Key: key, Detail: fmt.Sprintf(format, args...)})
+	}
+	what := "value"
+	if e.Chunked {
+		what = "chunks"
+	}
+	seg := db.segMgr.Seg(e.SegID)
+	end := e.ValOff + uint64(e.ValLen)
+	switch {
+	case seg == nil:
+		bad(ProblemOutOfRange, e.SegID, e.ValOff, uint64(e.ValLen), "%s in missing segment", what)
+		return out
+	case end < e.ValOff || end > uint64(seg.DataLen()):
+		bad(ProblemOutOfRange, e.SegID, e.ValOff, uint64(e.ValLen), "%s past data length %d", what, seg.DataLen())
+		return out
+	}
+	live[e.SegID] = append(live[e.SegID], extent{e.ValOff, e.ValOff + uint64(segment.SizeClass(e.ValLen)), key, what})
+	switch {
+	case e.Corrupt:
+		bad(ProblemCRC, e.SegID, e.ValOff, uint64(e.ValLen), "marked corrupt by recovery")
+		return out
+	case e.HasCRC && record.ValueCRC(seg.GetData()[e.ValOff:end]) != e.ValCRC:
+		bad(ProblemCRC, e.SegID, e.ValOff, uint64(e.ValLen), "%s checksum mismatch", what)
+		return out
+	case !e.Chunked:
+		return out
+	}
+	chunks := db.chunksOf(e)
+	if chunks == nil {
+		bad(ProblemOutOfRange, e.SegID, e.ValOff, uint64(e.ValLen), "invalid chunk list")
+		return out
+	}
+	for _, c := range chunks {
+		live[c.Seg] = append(live[c.Seg], extent{c.Off, c.Off + uint64(segment.SizeClass(c.Len)), key, "chunk"})
+		if record.ValueCRC(db.chunkData(c)) != c.CRC {
+			bad(ProblemCRC, c.Seg, c.Off, uint64(c.Len), "chunk checksum mismatch")
+		}
+	}
+	return out
+}
+
+// checkExtents 检查 seg 中存活块之间、与 log 区以及与 freelist 的重叠。
+func checkExtents(seg *segment.Segment, live []extent) []Problem {
+	var out []Problem
+	exts := live
+	seg.FreeBlocks(func(off uint64, class uint32) {
+		exts = append(exts, extent{off: off, end: off + uint64(class)})
+	})
+	sort.Slice(exts, func(i, j int) bool { return exts[i].off < exts[j].off })
+	// 扫描时分别记住 end 最大的存活块与空闲块，新块的起点落在它们之前即为重叠。
+	var lastLive, lastFree *extent
+	for i := range exts {
+		x := &exts[i]
+		p := Problem{Seg: seg.ID(), Off: x.off, Len: x.end - x.off, Key: x.key}
+		if x.key == "" {
+			if lastLive != nil && x.off < lastLive.end {
+				p.Kind, p.Key = ProblemFreeLive, lastLive.key
+				p.Detail = fmt.Sprintf("free block overlaps %s at %d", lastLive.what, lastLive.off)
+				out = append(out, p)
+			}
+			if lastFree == nil || x.end > lastFree.end {
+				lastFree = x
+			}
+			continue
+		}
+		if x.off < seg.LogEnd() {
+			p.Kind = ProblemInLog
+			p.Detail = fmt.Sprintf("%s below log end %d", x.what, seg.LogEnd())
+			out = append(out, p)
+		}
+		if lastLive != nil && x.off < lastLive.end {
+			p.Kind = ProblemOverlap
+			p.Detail = fmt.Sprintf("%s overlaps %s of key %q at %d", x.what, lastLive.what, lastLive.key, lastLive.off)
+			out = append(out, p)
+		}
+		if lastFree != nil && x.off < lastFree.end {
+			p.Kind = ProblemFreeLive
+			p.Detail = fmt.Sprintf("%s overlaps free block at %d", x.what, lastFree.off)
+			out = append(out, p)
+		}
+		if lastLive == nil || x.end > lastLive.end {
+			lastLive = x
+		}
+	}
+	return out
+}
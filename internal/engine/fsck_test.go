@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"shm_master/internal/fs"
+	"testing"
+)
+
+func problemKinds(t *testing.T, db *DB) map[ProblemKind]int {
+	t.Helper()
+	rep, err := db.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[ProblemKind]int)
+	for _, p := range rep.Problems {
+		kinds[p.Kind]++
+	}
+	return kinds
+}
+
+func TestCheckHealthy(t *testing.T) {
+	db, base := openTestDB(t)
+	val := bytes.Repeat([]byte{'f'}, 700)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("k%03d", i%90)
+		var err error
+		switch {
+		case i%11 == 0:
+			err = db.Del(key)
+		case i%23 == 0:
+			err = db.Apply([]BatchOp{{Key: key, Value: val[:i]}, {Key: "b", Value: val[:30]}})
+		case i%71 == 0:
+			err = db.Set(key, bytes.Repeat([]byte("chunk"), testSegSize/4))
+		default:
+			err = db.Set(key, val[:100+i])
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := db.Check()
+	if err != nil || !want.OK() {
+		t.Fatalf("writer: %+v %v", want, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ro, err := OpenReadOnly(base, testSegSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	rep, err := ro.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.OK() || rep.Keys != want.Keys {
+		t.Fatalf("read-only: %d keys, problems %v", rep.Keys, rep.Problems)
+	}
+}
+
+func TestCheckFindsProblems(t *testing.T) {
+	db, base := openTestDB(t)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if err := db.Set(k, bytes.Repeat([]byte(k), 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, _ := db.idx.Get("a")
+	b, _ := db.idx.Get("b")
+	seg := db.segMgr.Seg(a.SegID)
+
+	// 把 d 指向 a 的块、把 c 指向 log 区，再把 b 的块挂进 freelist。
+	d, _ := db.idx.Get("d")
+	d.ValOff = a.ValOff
+	db.idx.Set("d", d)
+	c, _ := db.idx.Get("c")
+	c.ValOff = seg.LogStart()
+	db.idx.Set("c", c)
+	seg.FreeBlock(b.ValOff, b.ValLen)
+	e := a
+	e.ValOff = uint64(seg.DataLen()) - 8
+	db.idx.Set("past", e)
+	kinds := problemKinds(t, db)
+	for _, k := range []ProblemKind{ProblemOverlap, ProblemInLog, ProblemFreeLive, ProblemOutOfRange, ProblemCRC} {
+		if kinds[k] == 0 {
+			t.Errorf("no %s problem in %v", k, kinds)
+		}
+	}
+
+	// 重新打开后索引恢复正常；再破坏一个 value、在 log 末尾写入垃圾并放一个孤儿段文件。
+	db = reopen(t, db, base)
+	if kinds := problemKinds(t, db); len(kinds) != 0 {
+		t.Fatalf("after reopen: %v", kinds)
+	}
+	b, _ = db.idx.Get("b")
+	seg = db.segMgr.Seg(b.SegID)
+	seg.GetData()[b.ValOff] ^= 0xff
+	copy(seg.GetData()[seg.LogEnd():], "junk")
+	if err := os.WriteFile(fs.SegPath(base, 42), make([]byte, testSegSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db = reopen(t, db, base)
+	kinds = problemKinds(t, db)
+	if kinds[ProblemCRC] != 1 || kinds[ProblemTruncated] != 1 || kinds[ProblemOrphan] != 1 || len(kinds) != 3 {
+		t.Errorf("after corruption: %v", kinds)
+	}
+}
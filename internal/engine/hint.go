@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"os"
+	"shm_master/consts"
+	"shm_master/internal/fs"
+	"shm_master/internal/hint"
+	"shm_master/internal/segment"
+)
+
+// appendSeg 追加新段，并在后台为刚被封存的原活跃段写 hint 文件。调用方需持有 writeMu。
+func (db *DB) appendSeg() (*segment.Segment, error) {
+	prev := db.lastSeg()
+	db.lifeMu.Lock()
+	seg, err := db.segMgr.ApnSeg()
+	db.lifeMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	db.metrics.segAppended()
+	if prev != nil {
+		// 在 writeMu 下 Add，Close 与 compactSeg 持有 writeMu 时 Wait 即可等到所有在途的 hint。
+		db.hintWG.Add(1)
+		go func() {
+			defer db.hintWG.Done()
+			db.writeHint(prev)
+		}()
+	}
+	return seg, nil
+}
+
+// writeHint 解析已封存段 seg 的 log，把整个段刷盘后再写出其 hint 文件，保证 hint 描述的记录都已落盘。
+// 失败只记日志：没有 hint 的段在 Open 时完整重放。已封存段的 log 与 valEnd 不再变化，
+// 解析只需 lifeMu 读锁，不阻塞写入；调用方不得持有 lifeMu。
+func (db *DB) writeHint(seg *segment.Segment) {
+	h := &hint.Hint{SegID: seg.ID(), CreatedAt: seg.Super().CreatedAt}
+	drop := func(b *pendingBatch) {
+		if b == nil {
+			return
+		}
+		for _, op := range b.ops {
+			if op.h.Op() == consts.FlagPut {
+				h.Ops = append(h.Ops, hint.Op{Drop: true, H: op.h, Key: op.key})
+			}
+		}
+	}
+	db.lifeMu.RLock()
+	b, logEnd, valEnd, maxSeq := walkLog(seg, seg.LogStart(), nil, func(ops []pendingOp, _ bool) bool {
+		// 与 replay 一致：遇到非法记录时，之前的操作（含同批次中的）已经生效。
+		for _, op := range ops {
+			if !db.checkRecord(seg, op.h) {
+				return false
+			}
+			h.Ops = append(h.Ops, hint.Op{H: op.h, Key: op.key})
+		}
+		return true
+	}, drop)
+	db.lifeMu.RUnlock()
+	// 封存段末尾未提交的批次在 Recover 时同样会被丢弃。
+	drop(b)
+	h.LogEnd, h.ValEnd, h.MaxSeq = logEnd, valEnd, maxSeq
+	if err := seg.SyncAll(); err != nil {
+		db.log.Warn("db: sync sealed segment failed, hint not written", "base", db.base, "seg", seg.ID(), "err", err)
+		return
+	}
+	if err := hint.Save(fs.HintPath(db.base, seg.ID()), h, db.segMgr.FileMode()); err != nil {
+		db.log.Warn("db: write hint failed", "base", db.base, "seg", seg.ID(), "err", err)
+	}
+}
+
+// readHint 读取并校验已封存段 seg 的 hint 文件，不改动任何状态。
+// hint 缺失、损坏或与段不符时返回 nil，由调用方完整解析 log。
+func (db *DB) readHint(seg *segment.Segment) *hint.Hint {
+	h, err := hint.Load(fs.HintPath(db.base, seg.ID()))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			db.log.Warn("db: ignoring hint", "base", db.base, "seg", seg.ID(), "err", err)
+		}
+		return nil
+	}
+	if h.SegID != seg.ID() || h.CreatedAt != seg.Super().CreatedAt ||
+		h.LogEnd < seg.LogStart() || h.LogEnd > h.ValEnd || h.ValEnd > uint64(seg.DataLen()) {
+		db.log.Warn("db: ignoring stale hint", "base", db.base, "seg", seg.ID())
+		return nil
+	}
+	for _, op := range h.Ops {
+		if !db.checkRecord(seg, op.H) {
+			db.log.Warn("db: ignoring hint with invalid op", "base", db.base, "seg", seg.ID(), "key", op.Key)
+			return nil
+		}
+	}
+	return h
+}
@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"shm_master/internal/errs"
+	"shm_master/internal/fs"
+	"shm_master/internal/index"
+	"testing"
+	"time"
+)
+
+// segState 汇总各段在 Recover 后重建出的边界与存活字节数。
+func segState(db *DB) string {
+	var b bytes.Buffer
+	for _, seg := range db.segMgr.Segments() {
+		fmt.Fprintf(&b, "%d:%d/%d/%d ", seg.ID(), seg.LogEnd(), seg.ValEnd(), seg.Live())
+	}
+	return b.String()
+}
+
+func indexState(db *DB) map[string]index.Entry {
+	m := make(map[string]index.Entry)
+	db.idx.Range(func(key string, e index.Entry) bool {
+		e.Unverified = false // 由 hint 恢复的项打开时未校验，重放的项已校验
+		m[key] = e
+		return true
+	})
+	return m
+}
+
+func TestHintMatchesReplay(t *testing.T) {
+	db, base := openTestDB(t)
+	val := bytes.Repeat([]byte{'h'}, 900)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("k%03d", i%120)
+		var err error
+		switch {
+		case i%17 == 0:
+			err = db.Del(key)
+		case i%29 == 0:
+			err = db.Apply([]BatchOp{{Key: key, Value: val[:i]}, {Key: "batch", Value: val[:50]}})
+		case i%41 == 0:
+			err = db.SetWithTTL(key, val, time.Hour)
+		default:
+			err = db.Set(key, val[:100+i])
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Set("big", bytes.Repeat([]byte("0123456789"), testSegSize/4)); err != nil {
+		t.Fatal(err)
+	}
+	db.hintWG.Wait()
+	sealed := db.segMgr.Segments()
+	sealed = sealed[:len(sealed)-1]
+	if len(sealed) < 3 {
+		t.Fatalf("only %d sealed segments", len(sealed))
+	}
+	for _, seg := range sealed {
+		if _, err := os.Stat(fs.HintPath(base, seg.ID())); err != nil {
+			t.Fatalf("hint for sealed segment %d: %v", seg.ID(), err)
+		}
+	}
+
+	db = reopen(t, db, base)
+	wantIdx, wantSegs := indexState(db), segState(db)
+	for _, seg := range sealed {
+		if err := os.Remove(fs.HintPath(base, seg.ID())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db = reopen(t, db, base) // 完整重放，并补写 hint
+	if got := segState(db); got != wantSegs {
+		t.Errorf("segments after replay:\n got %s\nwant %s", got, wantSegs)
+	}
+	gotIdx := indexState(db)
+	if len(gotIdx) != len(wantIdx) {
+		t.Fatalf("%d keys after replay, %d with hints", len(gotIdx), len(wantIdx))
+	}
+	for k, e := range wantIdx {
+		if gotIdx[k] != e {
+			t.Errorf("%s: replay %+v, hint %+v", k, gotIdx[k], e)
+		}
+	}
+	if _, ok, err := db.Get("big"); !ok || err != nil {
+		t.Errorf("big: ok=%v err=%v", ok, err)
+	}
+
+	// 抹掉第一个封存段的 log 后，只有 hint 能恢复其中的 key；hint 损坏则回退到（此时已残缺的）重放。
+	first := db.segMgr.Seg(sealed[0].ID())
+	clear(first.GetData()[first.LogStart() : first.LogStart()+64])
+	db = reopen(t, db, base)
+	if len(indexState(db)) != len(wantIdx) {
+		t.Error("hint was not used for the sealed segment")
+	}
+	hp := fs.HintPath(base, first.ID())
+	b, err := os.ReadFile(hp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)/2] ^= 0xff
+	if err := os.WriteFile(hp, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db = reopen(t, db, base)
+	if len(indexState(db)) == len(wantIdx) {
+		t.Error("corrupt hint was trusted")
+	}
+}
+
+// 由 hint 恢复的段打开时不读 value：损坏的 value 不计入 RecoveryReport，由 Get 与 Check 发现。
+func TestHintedSegmentValuesVerifiedLazily(t *testing.T) {
+	db, base := openTestDB(t)
+	val := bytes.Repeat([]byte{'v'}, 1500)
+	for i := 0; len(db.segMgr.Segments()) < 2; i++ {
+		if err := db.Set(fmt.Sprintf("k%03d", i), val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, _, err := db.Get("k000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got[0] ^= 0xff
+	db = reopen(t, db, base)
+	if rep := db.RecoveryReport(); !rep.Segments[0].FromHint || rep.Segments[0].Corrupt != 0 {
+		t.Errorf("first segment %+v, want recovered from hint without reading values", rep.Segments[0])
+	}
+	if db.verifyOnGet.Load() {
+		t.Fatal("verifyOnGet is on by default")
+	}
+	if _, _, err := db.Get("k000"); !errors.Is(err, errs.ErrCorrupt) {
+		t.Errorf("k000: want ErrCorrupt, got %v", err)
+	}
+	if _, ok, err := db.Get("k001"); !ok || err != nil {
+		t.Errorf("k001: ok=%v err=%v", ok, err)
+	}
+	rep, err := db.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Problems) != 1 || rep.Problems[0].Key != "k000" {
+		t.Errorf("check problems %+v, want k000 only", rep.Problems)
+	}
+	// 重写沿用原校验和，损坏仍须在 Get 时发现。
+	if ok, err := db.Expire("k000", time.Hour); !ok || err != nil {
+		t.Fatalf("Expire: ok=%v err=%v", ok, err)
+	}
+	if _, _, err := db.Get("k000"); !errors.Is(err, errs.ErrCorrupt) {
+		t.Errorf("k000 after rewrite: want ErrCorrupt, got %v", err)
+	}
+}
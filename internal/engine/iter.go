@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"iter"
+	"shm_master/internal/index"
+	"strings"
+	"time"
+)
+
+// All 遍历所有存活的 key 与 value（零拷贝，语义同 Get）。IndexOrdered 下按字典序，否则顺序不定。
+// 遍历不持有全局锁，循环体内可以读写 DB：开始前已存在且期间未被删除的 key 恰好出现一次，
+// 期间新增或删除的 key 可能出现也可能不出现；value 在访问到该 key 时读取。
+// 读取出错（如 ErrCorrupt）的 key 会被跳过，需要错误信息时使用 Scan。
+func (db *DB) All() iter.Seq2[string, []byte] {
+	return func(yield func(string, []byte) bool) {
+		db.idx.RangeKeys(func(keys []string) bool {
+			return db.yieldValues(keys, yield)
+		})
+	}
+}
+
+// Keys 遍历所有存活的 key，不读取 value，并发语义同 All。
+func (db *DB) Keys() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		db.idx.RangeKeys(func(keys []string) bool {
+			now := time.Now().UnixNano()
+			for _, key := range keys {
+				if e, ok := db.idx.Get(key); !ok || e.Expired(now) {
+					continue
+				}
+				if !yield(key) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+}
+
+// Prefix 遍历以 p 开头的 key 与 value，顺序与并发语义同 All；
+// IndexOrdered 下只访问匹配的区间，否则需要遍历全部 key。
+func (db *DB) Prefix(p string) iter.Seq2[string, []byte] {
+	return func(yield func(string, []byte) bool) {
+		if o, ok := db.idx.(index.Ordered); ok {
+			index.AscendKeys(o, p, prefixEnd(p), func(keys []string) bool {
+				return db.yieldValues(keys, yield)
+			})
+			return
+		}
+		var match []string
+		db.idx.RangeKeys(func(keys []string) bool {
+			match = match[:0]
+			for _, key := range keys {
+				if strings.HasPrefix(key, p) {
+					match = append(match, key)
+				}
+			}
+			return db.yieldValues(match, yield)
+		})
+	}
+}
+
+// yieldValues 依次读取 keys 的 value 交给 yield，跳过不存在或读取出错的 key；返回 false 表示停止。
+func (db *DB) yieldValues(keys []string, yield func(string, []byte) bool) bool {
+	for _, key := range keys {
+		v, ok, err := db.Get(key)
+		if err != nil || !ok {
+			continue
+		}
+		if !yield(key, v) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIteratorsUnderWrites(t *testing.T) {
+	db, _ := openTestDB(t)
+	for i := 0; i < 300; i++ {
+		if err := db.Set(fmt.Sprintf("player:%d", i), []byte("p")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Set("guild:1", []byte("g")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 循环体内写入不会死锁，已存在的 key 恰好出现一次。
+	seen := map[string]int{}
+	for k := range db.All() {
+		seen[k]++
+		if err := db.Set("new:"+k, []byte("n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 300; i++ {
+		if n := seen[fmt.Sprintf("player:%d", i)]; n != 1 {
+			t.Fatalf("player:%d seen %d times", i, n)
+		}
+	}
+
+	n := 0
+	for k, v := range db.Prefix("player:") {
+		if string(v) != "p" || k[:7] != "player:" {
+			t.Fatalf("Prefix yielded %s=%q", k, v)
+		}
+		n++
+	}
+	if n != 300 {
+		t.Errorf("Prefix visited %d keys", n)
+	}
+
+	n = 0
+	for range db.Keys() {
+		n++
+		if n == 10 {
+			break
+		}
+	}
+	if n != 10 {
+		t.Errorf("Keys break: n=%d", n)
+	}
+}
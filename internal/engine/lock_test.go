@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"shm_master/internal/errs"
+	"shm_master/internal/fs"
+	"testing"
+)
+
+func TestSingleWriterAndReadOnly(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "kv.data")
+	if _, err := OpenReadOnly(base, testSegSize); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("OpenReadOnly on empty dir: %v", err)
+	}
+	db, err := Open(base, testSegSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Set("k", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(base, testSegSize); !errors.Is(err, errs.ErrLocked) {
+		t.Fatalf("second writer: want ErrLocked, got %v", err)
+	}
+
+	before, _ := os.ReadFile(fs.ManifestPath(base))
+	ro, err := OpenReadOnly(base, testSegSize)
+	if err != nil {
+		t.Fatalf("OpenReadOnly: %v", err)
+	}
+	if got, ok, err := ro.Get("k"); err != nil || !ok || string(got) != "v" {
+		t.Errorf("ro.Get = %q ok=%v err=%v", got, ok, err)
+	}
+	if err := ro.Set("k", []byte("x")); !errors.Is(err, errs.ErrReadOnly) {
+		t.Errorf("ro.Set: want ErrReadOnly, got %v", err)
+	}
+	if _, err := ro.Compact(DefaultCompactRatio); !errors.Is(err, errs.ErrReadOnly) {
+		t.Errorf("ro.Compact: want ErrReadOnly, got %v", err)
+	}
+	if err := ro.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(fs.ManifestPath(base)); string(after) != string(before) {
+		t.Error("read-only open rewrote the manifest")
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db2, err := Open(base, testSegSize)
+	if err != nil {
+		t.Fatalf("reopen after Close: %v", err)
+	}
+	db2.Close()
+}
@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"shm_master/internal/errs"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// metricOp 计入延迟直方图的操作。
+type metricOp int
+
+const (
+	opGet metricOp = iota
+	opSet
+	opDel
+	opBatch
+	numMetricOps
+)
+
+var metricOpNames = [numMetricOps]string{"get", "set", "del", "batch"}
+
+// latencyBounds 延迟直方图各桶的上界。
+var latencyBounds = [...]time.Duration{
+	time.Microsecond,
+	5 * time.Microsecond,
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// opMetrics 单个操作的计数与延迟直方图；buckets 的最后一项收纳超过所有上界的样本。
+type opMetrics struct {
+	count   atomic.Uint64
+	errors  atomic.Uint64
+	sumNano atomic.Uint64
+	buckets [len(latencyBounds) + 1]atomic.Uint64
+}
+
+func (o *opMetrics) observe(d time.Duration, err error) {
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	o.buckets[i].Add(1)
+	o.sumNano.Add(uint64(d))
+	o.count.Add(1)
+	if err != nil {
+		o.errors.Add(1)
+	}
+}
+
+// Metrics 累计 DB 的运行指标，通过 Options.Metrics 启用，可被多个 DB 共用。
+// 未启用时各埋点只有一次 nil 判断，不分配内存。
+type Metrics struct {
+	ops            [numMetricOps]opMetrics
+	noSpace        atomic.Uint64
+	segAppends     atomic.Uint64
+	recoveries     atomic.Uint64
+	recoverNano    atomic.Int64 // 最近一次 Recover 的耗时
+	truncatedBytes atomic.Uint64
+}
+
+// NewMetrics 创建一组清零的指标。
+func NewMetrics() *Metrics { return &Metrics{} }
+
+// observe 记录一次 op 的耗时与结果。m 为 nil 时不做任何事，以下方法同。
+func (m *Metrics) observe(op metricOp, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.ops[op].observe(time.Since(start), err)
+	m.countNoSpace(err)
+}
+
+// countNoSpace 在 err 为 ErrNoSpace 时计数。
+func (m *Metrics) countNoSpace(err error) {
+	if m != nil && err != nil && errors.Is(err, errs.ErrNoSpace) {
+		m.noSpace.Add(1)
+	}
+}
+
+func (m *Metrics) segAppended() {
+	if m != nil {
+		m.segAppends.Add(1)
+	}
+}
+
+func (m *Metrics) recovered(d time.Duration, truncated uint64) {
+	if m == nil {
+		return
+	}
+	m.recoveries.Add(1)
+	m.recoverNano.Store(int64(d))
+	m.truncatedBytes.Add(truncated)
+}
+
+// Snapshot 以嵌套 map 返回当前指标，延迟以秒为单位，即 Publish 发布到 expvar 的内容。
+func (m *Metrics) Snapshot() map[string]any {
+	ops := make(map[string]any, numMetricOps)
+	for i := range m.ops {
+		o := &m.ops[i]
+		buckets := make(map[string]uint64, len(o.buckets))
+		var cum uint64
+		for j := range o.buckets {
+			cum += o.buckets[j].Load()
+			buckets[bucketLabel(j)] = cum
+		}
+		ops[metricOpNames[i]] = map[string]any{
+			"count":       o.count.Load(),
+			"errors":      o.errors.Load(),
+			"seconds_sum": time.Duration(o.sumNano.Load()).Seconds(),
+			"buckets":     buckets,
+		}
+	}
+	return map[string]any{
+		"ops":                      ops,
+		"nospace_errors":           m.noSpace.Load(),
+		"segment_appends":          m.segAppends.Load(),
+		"recoveries":               m.recoveries.Load(),
+		"recovery_seconds":         time.Duration(m.recoverNano.Load()).Seconds(),
+		"recovery_truncated_bytes": m.truncatedBytes.Load(),
+	}
+}
+
+// Publish 以 name 把指标发布到 expvar（/debug/vars）。同一个 name 只能发布一次，重复发布会 panic。
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return m.Snapshot() }))
+}
+
+// Handler 返回以 Prometheus 文本格式输出指标的 http.Handler，指标名以 shmmaster_ 开头。
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = m.WritePrometheus(w)
+	})
+}
+
+// WritePrometheus 以 Prometheus 文本格式把指标写入 w。
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	p := &promWriter{w: w}
+	p.family("shmmaster_ops_total", "counter", "Completed operations.")
+	for i := range m.ops {
+		p.printf("shmmaster_ops_total{op=%q} %d\n", metricOpNames[i], m.ops[i].count.Load())
+	}
+	p.family("shmmaster_op_errors_total", "counter", "Operations that returned an error.")
+	for i := range m.ops {
+		p.printf("shmmaster_op_errors_total{op=%q} %d\n", metricOpNames[i], m.ops[i].errors.Load())
+	}
+	p.family("shmmaster_op_duration_seconds", "histogram", "Operation latency.")
+	for i := range m.ops {
+		o, name := &m.ops[i], metricOpNames[i]
+		var cum uint64
+		for j := range o.buckets {
+			cum += o.buckets[j].Load()
+			p.printf("shmmaster_op_duration_seconds_bucket{op=%q,le=%q} %d\n", name, bucketLabel(j), cum)
+		}
+		p.printf("shmmaster_op_duration_seconds_sum{op=%q} %s\n", name, promFloat(time.Duration(o.sumNano.Load()).Seconds()))
+		p.printf("shmmaster_op_duration_seconds_count{op=%q} %d\n", name, cum)
+	}
+	p.family("shmmaster_nospace_errors_total", "counter", "Writes that failed with ErrNoSpace.")
+	p.printf("shmmaster_nospace_errors_total %d\n", m.noSpace.Load())
+	p.family("shmmaster_segment_appends_total", "counter", "Segments appended.")
+	p.printf("shmmaster_segment_appends_total %d\n", m.segAppends.Load())
+	p.family("shmmaster_recoveries_total", "counter", "Completed recoveries.")
+	p.printf("shmmaster_recoveries_total %d\n", m.recoveries.Load())
+	p.family("shmmaster_recovery_duration_seconds", "gauge", "Duration of the last recovery.")
+	p.printf("shmmaster_recovery_duration_seconds %s\n", promFloat(time.Duration(m.recoverNano.Load()).Seconds()))
+	p.family("shmmaster_recovery_truncated_bytes_total", "counter", "Log bytes discarded by recovery after a bad record.")
+	p.printf("shmmaster_recovery_truncated_bytes_total %d\n", m.truncatedBytes.Load())
+	return p.err
+}
+
+// bucketLabel 返回第 i 个桶的 le 标签。
+func bucketLabel(i int) string {
+	if i == len(latencyBounds) {
+		return "+Inf"
+	}
+	return promFloat(latencyBounds[i].Seconds())
+}
+
+func promFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// promWriter 记住第一个写错误，之后的输出全部跳过。
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *promWriter) family(name, typ, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (p *promWriter) printf(format string, args ...any) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
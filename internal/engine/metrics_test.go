@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"shm_master/internal/errs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsDisabledNoAllocs(t *testing.T) {
+	db, _ := openTestDB(t)
+	val := []byte("value")
+	if err := db.Set("k", val); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*Metrics{nil, NewMetrics()} {
+		db.metrics = m
+		if n := testing.AllocsPerRun(100, func() { _, _, _ = db.Get("k") }); n != 0 {
+			t.Errorf("metrics=%v: Get allocates %.1f times", m != nil, n)
+		}
+		if n := testing.AllocsPerRun(100, func() { _ = db.Set("k", val) }); n != 0 {
+			t.Errorf("metrics=%v: Set allocates %.1f times", m != nil, n)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	base := filepath.Join(t.TempDir(), "kv.data")
+	db, err := OpenWithOptions(base, Options{SegSize: testSegSize, MaxSegments: 2, Metrics: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	val := bytes.Repeat([]byte{'m'}, 1000)
+	var sets int
+	for i := 0; ; i++ {
+		err := db.Set(fmt.Sprintf("k%d", i), val)
+		sets++
+		if errors.Is(err, errs.ErrNoSpace) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := db.Get("k0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Del("k0"); err != nil {
+		t.Fatal(err)
+	}
+	// 截断 log：把末尾一条记录之后的空记录头改成垃圾。
+	last := db.lastSeg()
+	junk := last.GetData()[last.LogEnd() : last.LogEnd()+8]
+	copy(junk, "garbage!")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenWithOptions(base, Options{SegSize: testSegSize, MaxSegments: 2, Metrics: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	snap := m.Snapshot()
+	ops := snap["ops"].(map[string]any)
+	if got := ops["set"].(map[string]any)["count"]; got != uint64(sets) {
+		t.Errorf("set count %v, want %d", got, sets)
+	}
+	if got := ops["set"].(map[string]any)["errors"]; got != uint64(1) {
+		t.Errorf("set errors %v, want 1", got)
+	}
+	for k, want := range map[string]uint64{
+		"nospace_errors":           1,
+		"segment_appends":          1,
+		"recoveries":               2,
+		"recovery_truncated_bytes": 8,
+	} {
+		if snap[k] != want {
+			t.Errorf("%s = %v, want %d", k, snap[k], want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		fmt.Sprintf(`shmmaster_ops_total{op="set"} %d`, sets),
+		`shmmaster_ops_total{op="get"} 1`,
+		`shmmaster_ops_total{op="del"} 1`,
+		fmt.Sprintf(`shmmaster_op_duration_seconds_bucket{op="set",le="+Inf"} %d`, sets),
+		`shmmaster_op_duration_seconds_count{op="del"} 1`,
+		"# TYPE shmmaster_op_duration_seconds histogram",
+		"shmmaster_nospace_errors_total 1",
+		"shmmaster_segment_appends_total 1",
+		"shmmaster_recovery_truncated_bytes_total 8",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetricsTTLAndBatch(t *testing.T) {
+	m := NewMetrics()
+	db, err := OpenWithOptions(filepath.Join(t.TempDir(), "kv.data"), Options{SegSize: testSegSize, Metrics: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.SetWithTTL("t", []byte("v"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Apply([]BatchOp{{Key: "a", Value: []byte("1")}, {Key: "b", Del: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Apply([]BatchOp{{Key: ""}}); !errors.Is(err, errs.ErrBadArgument) {
+		t.Fatalf("empty key in batch: %v", err)
+	}
+	ops := m.Snapshot()["ops"].(map[string]any)
+	if got := ops["set"].(map[string]any)["count"]; got != uint64(1) {
+		t.Errorf("set count %v, want 1", got)
+	}
+	batch := ops["batch"].(map[string]any)
+	if batch["count"] != uint64(2) || batch["errors"] != uint64(1) {
+		t.Errorf("batch %v, want 2 calls with 1 error", batch)
+	}
+}
@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"shm_master/consts"
+	"shm_master/internal/errs"
+	"shm_master/internal/segment"
+	"time"
+)
+
+// IndexKind 选择内存索引的实现。
+type IndexKind int
+
+const (
+	// IndexHash 分片哈希索引：点查最快，Scan 需要先收集并排序匹配的 key。
+	IndexHash IndexKind = iota
+	// IndexOrdered 跳表索引：按 key 字典序组织，Scan/ScanPrefix 直接按序遍历。
+	IndexOrdered
+)
+
+const (
+	// DefaultSegSize 未指定 SegSize 时的单段大小。
+	DefaultSegSize = 64 << 20
+	// MinSegSize 允许的最小单段大小。
+	MinSegSize = 4 << 10
+	// DefaultShards 未指定 Shards 时 IndexHash 的分片数。
+	DefaultShards = consts.ShardSize
+)
+
+// Options Open 的配置，零值字段取默认值。
+type Options struct {
+	// SegSize 单段大小（字节），0 取 DefaultSegSize，不得小于 MinSegSize。
+	// 打开已有的库时必须与创建时一致。
+	SegSize int64
+	// Shards IndexHash 的分片数，0 取 DefaultShards；IndexOrdered 下忽略。
+	Shards int
+	// Index 内存索引实现，默认 IndexHash。
+	Index IndexKind
+	// ReadOnly 只读打开：不加写者锁，以 PROT_READ 映射已有段，所有写操作返回 ErrReadOnly。
+	ReadOnly bool
+	// FollowInterval 大于 0 时只读 DB 按此间隔自动 Refresh，跟随写者的新写入；要求 ReadOnly。
+	FollowInterval time.Duration
+	// Sync 刷盘策略，默认 SyncNever；SyncInterval 需同时给出 SyncInterval。只读时必须为 SyncNever。
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	// FileMode 新建段文件、manifest 与锁文件的权限，0 取 0644。
+	FileMode os.FileMode
+	// MaxSegments 存活段数上限，0 不限。
+	MaxSegments int
+	// MaxTotalSize 存活段文件总大小上限（字节），0 不限；折算为不超过它的段数，至少容纳一个段。
+	// 任一上限达到后，需要追加新段的写入返回 ErrNoSpace，可通过 Del 与 Compact 腾出空间。
+	// 上限只统计存活段；退役段在 ReleaseRetired 之前仍占用磁盘，见 Stats.RetiredSize。
+	MaxTotalSize int64
+	// Logger 记录打开过程与后台任务中的异常，nil 时不输出。
+	Logger *slog.Logger
+	// Metrics 非 nil 时采集操作计数、延迟、段追加与恢复耗时等指标，见 NewMetrics。
+	Metrics *Metrics
+	// StrictRecovery 为 true 时，任一段的 log 在非法记录处停止、丢弃了数据，Open 即返回 ErrCorrupt，
+	// 而不是带着较少的 key 打开；不修改任何文件，可随后用 shmmaster repair 抢救。
+	// 只读打开时活跃段末尾可能是写者正在写入的记录，也会被当作截断。
+	StrictRecovery bool
+}
+
+// withDefaults 校验 o 并填充默认值。
+func (o Options) withDefaults() (Options, error) {
+	bad := func(format string, args ...any) (Options, error) {
+		return o, fmt.Errorf("%w: "+format, append([]any{errs.ErrBadArgument}, args...)...)
+	}
+	if o.SegSize == 0 {
+		o.SegSize = DefaultSegSize
+	}
+	if o.SegSize < MinSegSize {
+		return bad("segment size %d < %d", o.SegSize, MinSegSize)
+	}
+	if o.Shards == 0 {
+		o.Shards = DefaultShards
+	}
+	if o.Shards < 0 {
+		return bad("shards %d", o.Shards)
+	}
+	if o.Index != IndexHash && o.Index != IndexOrdered {
+		return bad("index kind %d", o.Index)
+	}
+	if o.FollowInterval < 0 || (o.FollowInterval > 0 && !o.ReadOnly) {
+		return bad("follow interval %v requires read-only", o.FollowInterval)
+	}
+	switch o.Sync {
+	case SyncNever, SyncAlways:
+	case SyncInterval:
+		if o.SyncInterval <= 0 {
+			return bad("sync interval %v", o.SyncInterval)
+		}
+	default:
+		return bad("sync policy %d", o.Sync)
+	}
+	if o.ReadOnly && o.Sync != SyncNever {
+		return bad("sync policy on read-only db")
+	}
+	if o.FileMode == 0 {
+		o.FileMode = segment.DefaultFileMode
+	}
+	if o.FileMode&^os.ModePerm != 0 {
+		return bad("file mode %v", o.FileMode)
+	}
+	if o.MaxSegments < 0 || o.MaxTotalSize < 0 {
+		return bad("negative limit")
+	}
+	if o.MaxTotalSize > 0 && o.MaxTotalSize < o.SegSize {
+		return bad("max total size %d < segment size %d", o.MaxTotalSize, o.SegSize)
+	}
+	if o.Logger == nil {
+		o.Logger = slog.New(slog.DiscardHandler)
+	}
+	return o, nil
+}
+
+// maxSegs 合并 MaxSegments 与 MaxTotalSize，0 表示不限。
+func (o Options) maxSegs() int {
+	n := o.MaxSegments
+	if o.MaxTotalSize > 0 {
+		m := int(o.MaxTotalSize / o.SegSize)
+		if n == 0 || m < n {
+			n = m
+		}
+	}
+	return n
+}
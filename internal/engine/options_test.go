@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"shm_master/internal/errs"
+	"shm_master/internal/fs"
+	"testing"
+)
+
+func TestOptions(t *testing.T) {
+	dir := t.TempDir()
+	for _, o := range []Options{
+		{SegSize: MinSegSize - 1},
+		{SegSize: testSegSize, Shards: -1},
+		{SegSize: testSegSize, Index: IndexKind(9)},
+		{SegSize: testSegSize, FileMode: os.ModeDir | 0644},
+		{SegSize: testSegSize, MaxTotalSize: testSegSize - 1},
+		{SegSize: testSegSize, ReadOnly: true, Sync: SyncAlways},
+	} {
+		if _, err := OpenWithOptions(filepath.Join(dir, "bad"), o); !errors.Is(err, errs.ErrBadArgument) {
+			t.Errorf("%+v: want ErrBadArgument, got %v", o, err)
+		}
+	}
+
+	base := filepath.Join(dir, "kv.data")
+	db, err := OpenWithOptions(base, Options{SegSize: testSegSize, Shards: 3, FileMode: 0600, MaxTotalSize: 2*testSegSize + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, p := range []string{fs.SegPath(base, 0), fs.ManifestPath(base), fs.LockPath(base)} {
+		if st, err := os.Stat(p); err != nil || st.Mode().Perm() != 0600 {
+			t.Errorf("%s: mode %v err %v", p, st.Mode().Perm(), err)
+		}
+	}
+	val := make([]byte, 1024)
+	var full error
+	for i := 0; i < 1000 && full == nil; i++ {
+		full = db.Set(fmt.Sprintf("k%04d", i), val)
+	}
+	if !errors.Is(full, errs.ErrNoSpace) {
+		t.Fatalf("want ErrNoSpace at the size limit, got %v", full)
+	}
+	if n := len(db.segMgr.Segments()); n != 2 {
+		t.Errorf("%d segments, want 2", n)
+	}
+	for i := 0; i < 10; i++ {
+		if _, ok, err := db.Get(fmt.Sprintf("k%04d", i)); !ok || err != nil {
+			t.Errorf("k%04d: ok=%v err=%v", i, ok, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"io"
+	"shm_master/internal/errs"
+	"shm_master/internal/record"
+	"time"
+)
+
+// ValueReader 流式读取一个 value，进入每一块时先校验该块的 CRC32C。
+// 打开期间持有一个快照，保证所读的块不会被复用；用完必须 Close。
+type ValueReader struct {
+	db     *DB
+	snap   *Snapshot
+	key    string
+	chunks []record.Chunk // 非分块 value 视为只有一块
+	hasCRC bool
+	size   int64
+	off    int64
+	cur    int   // 当前块下标
+	curOff int64 // 当前块在 value 中的起点
+	ok     int   // 已校验的块数
+}
+
+// NewReader 打开 key 的流式读取器；key 不存在或已过期时 ok 为 false。
+func (db *DB) NewReader(key string) (*ValueReader, bool, error) {
+	snap := db.Snapshot()
+	e, ok := snap.entry(key)
+	if !ok || e.Expired(time.Now().UnixNano()) {
+		snap.Release()
+		return nil, false, nil
+	}
+	if e.Corrupt {
+		snap.Release()
+		return nil, false, corruptKey(key)
+	}
+	r := &ValueReader{db: db, snap: snap, key: key, hasCRC: e.HasCRC}
+	db.lifeMu.RLock()
+	var err error
+	switch {
+	case db.segMgr.Last() == nil:
+		err = errs.ErrClosed
+	case e.Chunked:
+		if r.chunks = db.chunksOf(e); r.chunks == nil {
+			err = corruptKey(key)
+		}
+	default:
+		seg := db.segMgr.Mapped(e.SegID)
+		if seg == nil || e.ValOff+uint64(e.ValLen) > uint64(seg.DataLen()) {
+			err = errs.ErrCorrupt
+		}
+		r.chunks = []record.Chunk{{Seg: e.SegID, Off: e.ValOff, Len: e.ValLen, CRC: e.ValCRC}}
+	}
+	db.lifeMu.RUnlock()
+	if err != nil {
+		snap.Release()
+		return nil, false, err
+	}
+	for _, c := range r.chunks {
+		r.size += int64(c.Len)
+	}
+	return r, true, nil
+}
+
+// Size 返回 value 总长度。
+func (r *ValueReader) Size() int64 { return r.size }
+
+// Read 实现 io.Reader。
+func (r *ValueReader) Read(p []byte) (int, error) {
+	if r.snap == nil {
+		return 0, errs.ErrClosed
+	}
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	r.db.lifeMu.RLock()
+	defer r.db.lifeMu.RUnlock()
+	if r.db.segMgr.Last() == nil {
+		return 0, errs.ErrClosed
+	}
+	n := 0
+	for n < len(p) && r.off < r.size {
+		c := r.chunks[r.cur]
+		data := r.db.chunkData(c)
+		if r.ok <= r.cur {
+			if r.hasCRC && record.ValueCRC(data) != c.CRC {
+				return n, corruptKey(r.key)
+			}
+			r.ok = r.cur + 1
+		}
+		m := copy(p[n:], data[r.off-r.curOff:])
+		n += m
+		r.off += int64(m)
+		if r.off == r.curOff+int64(c.Len) && r.cur+1 < len(r.chunks) {
+			r.curOff = r.off
+			r.cur++
+		}
+	}
+	return n, nil
+}
+
+// Close 释放读取器持有的快照。重复调用无副作用。
+func (r *ValueReader) Close() error {
+	if r.snap != nil {
+		r.snap.Release()
+		r.snap = nil
+	}
+	return nil
+}
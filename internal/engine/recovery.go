@@ -1,90 +1,579 @@
 package engine
 
 import (
+	"fmt"
+	"runtime"
 	"shm_master/consts"
+	"shm_master/internal/errs"
 	"shm_master/internal/index"
 	"shm_master/internal/record"
 	"shm_master/internal/segment"
+	"slices"
+	"time"
 )
 
-// Recover 重放所有段的 log，重建 index 并更新每段的 logEnd/valEnd。
+// Recover 重放所有段的 log，重建 index、每段的 freelist 以及 logEnd/valEnd。
+// 各段先由 recoverWorkers 个协程并行解析成按 log 顺序排列的操作表：已封存的段优先读取 hint 文件，
+// 其余段逐条解析 log，两者都校验 value 与分块；再按段顺序依次合并，结果与逐段顺序重放一致。
+// 各段在何处、因何停止解析记入 RecoveryReport。
 func (db *DB) Recover() error {
+	db.writeHints(db.recoverSegs())
+	return nil
+}
+
+// recoverSegs 执行 Recover 的重放，返回需要补写 hint 的已封存段。
+func (db *DB) recoverSegs() (noHint []*segment.Segment) {
 	db.writeMu.Lock()
 	defer db.writeMu.Unlock()
+	db.hintWG.Wait()
 
+	start := time.Now()
 	db.idx.Clear()
+	db.seq = 0
 	segs := db.segMgr.Segments()
-	if len(segs) == 0 {
-		return nil
+	for _, seg := range segs {
+		seg.ResetFreeTruth()
+		seg.ResetLive()
+		seg.SetLogEnd(seg.LogStart())
+		seg.SetValEnd(uint64(seg.DataLen()))
+	}
+	db.tail, db.tailSeg = nil, 0
+	tab := make(map[string]index.Entry)
+	var truncated uint64
+	rep := &RecoveryReport{Segments: make([]SegmentRecovery, 0, len(segs))}
+	db.planSegs(segs, func(p *segPlan) {
+		sr := SegmentRecovery{
+			ID:             p.seg.ID(),
+			FromHint:       p.fromHint,
+			DroppedBatches: p.droppedBatches,
+			DroppedBytes:   p.droppedBytes,
+			LogEnd:         p.logEnd,
+			Reason:         p.reason,
+			Discarded:      p.truncated,
+		}
+		for _, op := range p.ops {
+			if db.mergeOp(tab, p.seg, op) {
+				sr.Corrupt++
+			}
+			if !op.drop {
+				sr.Applied++
+			}
+		}
+		rep.Segments = append(rep.Segments, sr)
+		db.seq = max(db.seq, p.maxSeq)
+		p.seg.SetLogEnd(p.logEnd)
+		// 本段尾部可能还有分块，mergeOp 已据此压低过 valEnd。
+		p.seg.SetValEnd(min(p.valEnd, p.seg.ValEnd()))
+		db.tail, db.tailSeg = p.tail, p.seg.ID()
+		truncated += p.truncated
+		// 被截断的段不写 hint：否则下次打开直接读 hint，截断便不再出现在报告里。
+		if !p.fromHint && p.truncated == 0 && p.seg != segs[len(segs)-1] {
+			noHint = append(noHint, p.seg)
+		}
+	})
+	for k, e := range tab {
+		db.idx.Set(k, e)
+		db.addLive(k, e)
+	}
+	rep.Duration = time.Since(start)
+	db.recovery = rep
+	db.metrics.recovered(rep.Duration, truncated)
+	return noHint
+}
+
+// writeHints 为 Recover 完整解析过的已封存段补写 hint，下次打开即可跳过这些段的解析。
+// 只读时不做任何事；期间已被退役的段跳过。
+func (db *DB) writeHints(segs []*segment.Segment) {
+	if db.readOnly || len(segs) == 0 {
+		return
 	}
-	lastID := segs[len(segs)-1].ID()
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
 	for _, seg := range segs {
-		if seg.ID() == lastID {
-			seg.ResetFreeTruth()
+		if db.segMgr.Seg(seg.ID()) == seg {
+			db.writeHint(seg)
 		}
-		if err := db.recoverOne(seg, lastID); err != nil {
-			return err
+	}
+}
+
+// segPlan 一个段的解析结果：按 log 顺序排列的生效操作，以及 log 末尾、value 区起点与最大序列号。
+type segPlan struct {
+	seg      *segment.Segment
+	ops      []planOp
+	tail     *pendingBatch // 只读打开时活跃段末尾尚未提交的批次
+	logEnd   uint64
+	valEnd   uint64
+	maxSeq   uint64
+	fromHint bool
+	// truncated 为解析在非法记录处停止时，其后被丢弃的 log 字节数，reason 为停止原因。
+	truncated uint64
+	reason    string
+	// droppedBatches、droppedBytes 为丢弃的未提交或被撕裂批次数及其记录字节数。
+	droppedBatches int
+	droppedBytes   uint64
+}
+
+// SegmentRecovery 单个段在最近一次 Recover 中的解析结果。
+type SegmentRecovery struct {
+	ID       uint32 `json:"id"`
+	FromHint bool   `json:"from_hint"` // 由 hint 文件恢复，未解析 log
+	// Applied 为生效的 Put/Del 记录数，不含未提交批次中被丢弃的操作；
+	// Corrupt 为其中 value 或分块校验失败、被标记为损坏的 Put 数；由 hint 恢复的段打开时不读 value，
+	// 只统计分块清单损坏的，其余留给 Get 与 Check 发现。
+	Applied int `json:"applied"`
+	Corrupt int `json:"corrupt"`
+	// DroppedBatches 为因未提交或被撕裂而整批丢弃的批次数，DroppedBytes 为其 Begin 与操作记录的字节数。
+	// 由 hint 恢复的段不统计。
+	DroppedBatches int    `json:"dropped_batches"`
+	DroppedBytes   uint64 `json:"dropped_bytes"`
+	// LogEnd 为解析停止的位置。log 在非法记录处停止时 Reason 说明原因，
+	// Discarded 为其后被丢弃的字节数；正常结束时两者均为零值。
+	LogEnd    uint64 `json:"log_end"`
+	Reason    string `json:"reason,omitempty"`
+	Discarded uint64 `json:"discarded"`
+}
+
+// RecoveryReport 最近一次 Recover 的结果，Segments 按段 id 升序排列。
+type RecoveryReport struct {
+	Segments []SegmentRecovery `json:"segments"`
+	Duration time.Duration     `json:"duration"`
+}
+
+// Truncated 返回 log 在非法记录处停止、丢弃了数据的段。
+func (r *RecoveryReport) Truncated() []SegmentRecovery {
+	var out []SegmentRecovery
+	for _, s := range r.Segments {
+		if s.Discarded > 0 {
+			out = append(out, s)
 		}
 	}
-	return nil
+	return out
 }
 
-func (db *DB) recoverOne(seg *segment.Segment, lastID uint32) error {
-	data := seg.GetData()
-	off := uint64(0)
-	fileLimit := uint64(len(data))
-	minValOff := fileLimit
-Loop:
-	for {
-		if off+consts.HeaderSize > minValOff {
-			break
+// strictErr 在有段被截断时返回包装 ErrCorrupt 的错误，供 Options.StrictRecovery 使用。
+func (r *RecoveryReport) strictErr() error {
+	bad := r.Truncated()
+	if len(bad) == 0 {
+		return nil
+	}
+	s := bad[0]
+	return fmt.Errorf("%w: segment %d log stopped at %d (%s), %d bytes discarded; %d segment(s) truncated",
+		errs.ErrCorrupt, s.ID, s.LogEnd, s.Reason, s.Discarded, len(bad))
+}
+
+// RecoveryReport 返回打开时 Recover 的结果；跟随模式的增量重放不计入。
+func (db *DB) RecoveryReport() *RecoveryReport {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if db.recovery == nil {
+		return &RecoveryReport{}
+	}
+	rep := *db.recovery
+	rep.Segments = slices.Clone(rep.Segments)
+	return &rep
+}
+
+// planOp 解析出的一个操作。drop 为 true 时是被丢弃批次中的 Put，只需归还其 value 块；
+// badVal、badChunks 为解析时 value 与各分块的校验结果，unverified 表示来自 hint、未做校验。
+type planOp struct {
+	h          record.Header
+	key        string
+	drop       bool
+	badVal     bool
+	badChunks  bool
+	unverified bool
+}
+
+// planSegs 并行解析 segs，并按段顺序把结果交给 merge。同时持有的解析结果不超过 recoverWorkers 个，
+// 以免段很多时所有操作表同时驻留内存。
+func (db *DB) planSegs(segs []*segment.Segment, merge func(p *segPlan)) {
+	workers := db.recoverWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	results := make([]chan *segPlan, len(segs))
+	for i := range results {
+		results[i] = make(chan *segPlan, 1)
+	}
+	slots := make(chan struct{}, workers)
+	go func() {
+		for i, seg := range segs {
+			slots <- struct{}{}
+			go func() {
+				results[i] <- db.planSeg(seg, i == len(segs)-1)
+			}()
 		}
-		h := record.DecodeHeader(data[off : off+consts.HeaderSize])
-		if h.Magic != consts.Magic || h.Ver != consts.Version || h.KeyLen == 0 {
-			break
+	}()
+	for i := range segs {
+		merge(<-results[i])
+		<-slots
+	}
+}
+
+// planSeg 解析单个段，不修改索引与其它段。last 表示 seg 为活跃段：活跃段总是解析 log。
+func (db *DB) planSeg(seg *segment.Segment, last bool) *segPlan {
+	p := &segPlan{seg: seg}
+	if !last {
+		if h := db.readHint(seg); h != nil {
+			// 打开时不读 value，否则启动仍与数据量成正比；hint 不能证明数据未被损坏，改由 Get 校验。
+			p.ops = make([]planOp, len(h.Ops))
+			for i, op := range h.Ops {
+				p.ops[i] = planOp{h: op.H, key: op.Key, drop: op.Drop, unverified: !op.Drop}
+			}
+			p.logEnd, p.valEnd, p.maxSeq, p.fromHint = h.LogEnd, h.ValEnd, h.MaxSeq, true
+			return p
 		}
-		recLen := uint64(consts.HeaderSize) + uint64(h.KeyLen)
-		if off+recLen > minValOff {
-			break
+	}
+	drop := func(b *pendingBatch) {
+		if b == nil {
+			return
 		}
-		keyStart := off + consts.HeaderSize
-		keyBytes := data[keyStart : keyStart+uint64(h.KeyLen)]
-		if h.Flags == consts.FlagPut {
-			if h.ValOff > fileLimit || uint64(h.ValLen) > fileLimit || h.ValOff+uint64(h.ValLen) > fileLimit {
-				break
+		p.droppedBatches++
+		p.droppedBytes += b.size
+		for _, op := range b.ops {
+			if op.h.Op() == consts.FlagPut {
+				p.ops = append(p.ops, planOp{h: op.h, key: op.key, drop: true})
 			}
-			if h.ValOff < off+recLen {
-				break
+		}
+	}
+	b, logEnd, valEnd, maxSeq := walkLog(seg, seg.LogStart(), nil, func(ops []pendingOp, _ bool) bool {
+		for _, op := range ops {
+			if !db.checkRecord(seg, op.h) {
+				return false
 			}
+			badVal, badChunks := db.verifyRecord(seg, op.h)
+			p.ops = append(p.ops, planOp{h: op.h, key: op.key, badVal: badVal, badChunks: badChunks})
 		}
-		if record.CalcCRC(h.Flags, h.KeyLen, h.ValLen, h.ValOff, keyBytes) != h.CRC32 {
-			break
+		return true
+	}, drop)
+	if last && db.readOnly {
+		p.tail = b
+	} else if b != nil {
+		// 批次不会跨段；写者重启时段末未提交的批次一律丢弃。它之后的写入从 logEnd 处续写，
+		// 序列号须越过整个批次预留的范围，否则会被下次解析当作该批次缺失的操作。
+		drop(b)
+		maxSeq = max(maxSeq, b.begin+b.n+1)
+	}
+	p.logEnd, p.valEnd, p.maxSeq = logEnd, valEnd, maxSeq
+	limit := min(valEnd, seg.ValEnd())
+	if p.truncated = truncatedBytes(seg, logEnd, limit); p.truncated > 0 {
+		p.reason = db.stopReason(seg, logEnd, limit)
+	}
+	return p
+}
+
+// stopReason 说明 seg 的 log 为什么在 off 处停止解析，limit 为解析时的 value 区起点。
+func (db *DB) stopReason(seg *segment.Segment, off, limit uint64) string {
+	h, _, ok := record.Parse(seg.GetData(), off, limit, seg.ID())
+	switch {
+	case !ok:
+		return record.Diagnose(seg.GetData(), off, limit)
+	case h.Op() == consts.FlagCommit:
+		return "batch contains an invalid record"
+	case h.Op() == consts.FlagPut && h.ValSeg > seg.ID():
+		return fmt.Sprintf("value in later segment %d", h.ValSeg)
+	case !db.checkRecord(seg, h):
+		return fmt.Sprintf("value past end of segment %d", h.ValSeg)
+	}
+	return "value overlaps log"
+}
+
+// truncatedBytes 返回 seg 的 log 在 logEnd 处停止解析后被丢弃的字节数：logEnd 处不是全零的空记录头时，
+// 计到 valEnd 之前最后一个非零字节为止；正常结束的 log 返回 0。
+func truncatedBytes(seg *segment.Segment, logEnd, valEnd uint64) uint64 {
+	data := seg.GetData()
+	valEnd = min(valEnd, uint64(len(data)))
+	if logEnd >= valEnd {
+		return 0
+	}
+	gap := data[logEnd:valEnd]
+	head := gap[:min(len(gap), consts.HeaderSize)]
+	if !slices.ContainsFunc(head, func(b byte) bool { return b != 0 }) {
+		return 0
+	}
+	n := len(gap)
+	for n > 0 && gap[n-1] == 0 {
+		n--
+	}
+	return uint64(n)
+}
+
+// verifyRecord 校验 seg 中 Put 记录 h 的 value 与各分块的 CRC。分块清单本身的合法性留给合并时的
+// recoverChunks。落在本段尾部的分块会压低本段 valEnd，使后续解析不会越过它们。
+func (db *DB) verifyRecord(seg *segment.Segment, h record.Header) (badVal, badChunks bool) {
+	if h.Op() != consts.FlagPut {
+		return false, false
+	}
+	vseg := db.segMgr.Mapped(h.ValSeg)
+	if h.HasValCRC() && vseg != nil && record.ValueCRC(vseg.GetData()[h.ValOff:h.ValOff+uint64(h.ValLen)]) != h.ValCRC {
+		return true, false
+	}
+	if !h.Chunked() {
+		return false, false
+	}
+	for _, c := range db.chunksOf(entryOf(seg, h)) {
+		if c.Seg > seg.ID() {
+			return false, true
 		}
-		if h.Flags == consts.FlagPut && h.ValOff < minValOff {
-			minValOff = h.ValOff
+		if c.Seg == seg.ID() && c.Off < seg.ValEnd() {
+			seg.SetValEnd(c.Off)
 		}
-		k := string(keyBytes)
-		old, hadOld := db.idx.Get(k)
-		switch h.Flags {
-		case consts.FlagPut:
-			if seg.ID() == lastID && hadOld && old.SegID == lastID {
-				seg.FreeBlock(old.ValOff, old.ValLen)
+		if record.ValueCRC(db.chunkData(c)) != c.CRC {
+			badChunks = true
+		}
+	}
+	return false, badChunks
+}
+
+// mergeOp 把 seg 中的一个操作合并到 tab 与 freelist，效果与 applyRecord 相同；返回写入的索引项是否损坏。
+func (db *DB) mergeOp(tab map[string]index.Entry, seg *segment.Segment, op planOp) (corrupt bool) {
+	if op.drop {
+		if vseg := db.segMgr.Seg(op.h.ValSeg); vseg != nil {
+			vseg.FreeBlock(op.h.ValOff, op.h.ValLen)
+		}
+		return false
+	}
+	old, hadOld := tab[op.key]
+	if op.h.Op() == consts.FlagDel {
+		seg.AddTomb()
+		if hadOld {
+			delete(tab, op.key)
+			db.releaseBlock(old)
+		}
+		return false
+	}
+	e := entryOf(seg, op.h)
+	e.Corrupt, e.Unverified = op.badVal, op.unverified
+	if e.Chunked && !e.Corrupt {
+		e.Corrupt = !db.recoverChunks(seg, e, false) || op.badChunks
+	}
+	tab[op.key] = e
+	if hadOld {
+		db.releaseBlock(old)
+	}
+	if vseg := db.segMgr.Mapped(op.h.ValSeg); vseg != nil {
+		vseg.MarkUsed(op.h.ValOff)
+	}
+	return e.Corrupt
+}
+
+// pendingBatch 重放中尚未见到 Commit 的批次。
+type pendingBatch struct {
+	begin uint64
+	n     uint64
+	ops   []pendingOp
+	size  uint64 // Begin 与已解析操作的记录字节数
+}
+
+type pendingOp struct {
+	h   record.Header
+	key string
+}
+
+// replay 从 seg 的 logEnd 继续解析并应用记录，b 为上次解析到段末时尚未提交的批次；
+// 返回解析结束时仍未提交的批次，由调用方决定丢弃还是留到下次继续。
+func (db *DB) replay(seg *segment.Segment, b *pendingBatch) *pendingBatch {
+	b, logEnd, valEnd, maxSeq := walkLog(seg, seg.LogEnd(), b, func(ops []pendingOp, batch bool) bool {
+		if batch {
+			return db.applyBatch(seg, ops)
+		}
+		return db.applyRecord(seg, ops[0].h, ops[0].key)
+	}, db.dropBatch)
+	db.seq = max(db.seq, maxSeq)
+	seg.SetLogEnd(logEnd)
+	// 本段尾部可能还有分块，applyRecord 已据此压低过 valEnd。
+	seg.SetValEnd(min(valEnd, seg.ValEnd()))
+	return b
+}
+
+// walkLog 从 off 处解析 seg 的 log 并按批次语义分发：单条 Put/Del 与已提交的批次交给 apply，
+// 被撕裂或被新 Begin 打断的批次交给 drop；apply 返回 false 时停止。b 为上次解析到段末时尚未提交的批次。
+// 返回解析结束时仍未提交的批次、log 末尾、本次解析到的 value 区起点以及最大序列号。
+func walkLog(seg *segment.Segment, off uint64, b *pendingBatch, apply func(ops []pendingOp, batch bool) bool,
+	drop func(b *pendingBatch)) (rest *pendingBatch, logEnd, valEnd, maxSeq uint64) {
+	logEnd, valEnd = scanLogFrom(seg, off, func(h record.Header, keyBytes []byte) bool {
+		maxSeq = max(maxSeq, h.Seq)
+		switch h.Op() {
+		case consts.FlagBegin:
+			drop(b)
+			b = &pendingBatch{begin: h.Seq, n: h.Ext, size: h.RecLen()}
+			return true
+		case consts.FlagCommit:
+			if b == nil || h.Ext != b.begin || uint64(len(b.ops)) != b.n {
+				drop(b)
+				b = nil
+				return true
 			}
-			if seg.ID() == lastID {
-				seg.MarkUsed(h.ValOff)
+			ok := apply(b.ops, true)
+			b = nil
+			return ok
+		}
+		if b != nil {
+			// 批内操作的序列号紧接 Begin 连续分配；不连续说明批次在此之前被撕裂，
+			// 该记录是重启后写入的普通记录。
+			if n := uint64(len(b.ops)); n < b.n && h.Seq == b.begin+1+n {
+				b.ops = append(b.ops, pendingOp{h: h, key: string(keyBytes)})
+				b.size += h.RecLen()
+				return true
 			}
-			db.idx.Set(k, index.Entry{SegID: seg.ID(), ValOff: h.ValOff, ValLen: h.ValLen})
-		case consts.FlagDel:
-			if seg.ID() == lastID && hadOld && old.SegID == lastID {
-				seg.FreeBlock(old.ValOff, old.ValLen)
+			// 批内操作数已满却没有 Commit，或序列号不连续：批次被撕裂，丢弃后按普通记录处理。
+			drop(b)
+			b = nil
+		}
+		return apply([]pendingOp{{h: h, key: string(keyBytes)}}, false)
+	})
+	return b, logEnd, valEnd, maxSeq
+}
+
+// applyBatch 在 lifeMu 写锁下应用已提交的批次，并发的 Get 看到的是整批之前或之后的状态。
+func (db *DB) applyBatch(seg *segment.Segment, ops []pendingOp) bool {
+	db.lifeMu.Lock()
+	defer db.lifeMu.Unlock()
+	for _, op := range ops {
+		if !db.applyRecord(seg, op.h, op.key) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRecord 报告 seg 中的 Put/Del 记录 h 是否可以应用；value 只会落在本段或更早的段里。
+func (db *DB) checkRecord(seg *segment.Segment, h record.Header) bool {
+	switch h.Op() {
+	case consts.FlagPut:
+		if h.ValSeg > seg.ID() {
+			return false
+		}
+		vseg := db.segMgr.Mapped(h.ValSeg)
+		return vseg == nil || h.ValOff+uint64(h.ValLen) <= uint64(vseg.DataLen())
+	case consts.FlagDel:
+		return true
+	}
+	return false
+}
+
+// entryOf 返回 seg 中 Put 记录 h 对应的索引项。
+func entryOf(seg *segment.Segment, h record.Header) index.Entry {
+	return index.Entry{
+		SegID:    h.ValSeg,
+		ValOff:   h.ValOff,
+		ValLen:   h.ValLen,
+		LogSeg:   seg.ID(),
+		ValCRC:   h.ValCRC,
+		HasCRC:   h.HasValCRC(),
+		Seq:      h.Seq,
+		ExpireAt: h.ExpireAt(),
+		Chunked:  h.Chunked(),
+	}
+}
+
+// applyRecord 把一条 Put/Del 记录应用到索引与 freelist，并校验 value 与各分块的 CRC；
+// 返回 false 表示记录非法、应停止重放。供跟随模式增量重放使用。
+func (db *DB) applyRecord(seg *segment.Segment, h record.Header, k string) bool {
+	if !db.checkRecord(seg, h) {
+		return false
+	}
+	if h.Op() == consts.FlagDel {
+		seg.AddTomb()
+		if old, hadOld := db.dropEntry(k); hadOld {
+			db.freeEntry(old)
+		}
+		return true
+	}
+	// 跟随模式下记录可能位于刚被写者退役、但仍保持映射的段里。
+	vseg := db.segMgr.Mapped(h.ValSeg)
+	e := entryOf(seg, h)
+	if e.HasCRC && vseg != nil {
+		// 头部完好而 value 校验失败：只把该 key 标记为损坏，继续重放。
+		val := vseg.GetData()[h.ValOff : h.ValOff+uint64(h.ValLen)]
+		e.Corrupt = record.ValueCRC(val) != h.ValCRC
+	}
+	if h.Chunked() && !e.Corrupt {
+		e.Corrupt = !db.recoverChunks(seg, e, true)
+	}
+	old, hadOld := db.putEntry(k, e)
+	if hadOld {
+		db.freeEntry(old)
+	}
+	if vseg != nil {
+		vseg.MarkUsed(h.ValOff)
+	}
+	return true
+}
+
+// recoverChunks 标记分块 value 的各块已占用，verify 时还校验其数据；返回 false 表示清单或某块已损坏。
+// 分块可能占用某段 valEnd 之下的尾部空间（写分块期间追加了新段），此时压低该段的 valEnd。
+func (db *DB) recoverChunks(seg *segment.Segment, e index.Entry, verify bool) bool {
+	chunks := db.chunksOf(e)
+	if chunks == nil {
+		return false
+	}
+	for _, c := range chunks {
+		if c.Seg > seg.ID() {
+			return false
+		}
+	}
+	ok := true
+	for _, c := range chunks {
+		cseg := db.segMgr.Mapped(c.Seg)
+		cseg.MarkUsed(c.Off)
+		if c.Off < cseg.ValEnd() {
+			cseg.SetValEnd(c.Off)
+		}
+		if verify && record.ValueCRC(db.chunkData(c)) != c.CRC {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// dropBatch 丢弃未提交的批次，把它已占用的 value 块还给 freelist。
+func (db *DB) dropBatch(b *pendingBatch) {
+	if b == nil {
+		return
+	}
+	for _, op := range b.ops {
+		if op.h.Op() != consts.FlagPut {
+			continue
+		}
+		if vseg := db.segMgr.Seg(op.h.ValSeg); vseg != nil {
+			vseg.FreeBlock(op.h.ValOff, op.h.ValLen)
+		}
+	}
+}
+
+// scanLog 从 LogStart 开始顺序解析 seg 的 log 区（v1/v2 均可），对每条头部校验通过的记录回调 fn，
+// 遇到非法记录或 fn 返回 false 时停止；返回 log 末尾与 value 区起点。
+// value 本身的校验以及位于其它段的 value 范围由调用方负责。
+func scanLog(seg *segment.Segment, fn func(h record.Header, key []byte) bool) (logEnd, valEnd uint64) {
+	return scanLogFrom(seg, seg.LogStart(), fn)
+}
+
+// scanLogFrom 同 scanLog，但从 off 处开始解析；返回的 valEnd 只反映本次解析到的记录。
+func scanLogFrom(seg *segment.Segment, off uint64, fn func(h record.Header, key []byte) bool) (logEnd, valEnd uint64) {
+	data := seg.GetData()
+	minValOff := uint64(len(data))
+	for {
+		// seg.ValEnd() 覆盖落在本段尾部的分块，Recover 时由 fn 逐步压低。
+		h, keyBytes, ok := record.Parse(data, off, min(minValOff, seg.ValEnd()), seg.ID())
+		if !ok {
+			break
+		}
+		recLen := h.RecLen()
+		local := h.Op() == consts.FlagPut && h.ValSeg == seg.ID()
+		if local {
+			end := h.ValOff + uint64(h.ValLen)
+			if end < h.ValOff || end > uint64(len(data)) || h.ValOff < off+recLen {
+				break
 			}
-			db.idx.Del(k)
-		default:
-			break Loop
+		}
+		if !fn(h, keyBytes) {
+			break
+		}
+		if local && h.ValOff < minValOff {
+			minValOff = h.ValOff
 		}
 		off += recLen
 	}
-	seg.SetLogEnd(off)
-	seg.SetValEnd(minValOff)
-	return nil
+	return off, minValOff
 }
@@ -0,0 +1,363 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"shm_master/consts"
+	"shm_master/internal/errs"
+	"shm_master/internal/fs"
+	"shm_master/internal/index"
+	"shm_master/internal/record"
+	"strings"
+	"testing"
+	"time"
+)
+
+func reopen(t *testing.T, db *DB, base string) *DB {
+	t.Helper()
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	db2, err := Open(base, testSegSize)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	t.Cleanup(func() { _ = db2.Close() })
+	return db2
+}
+
+func TestRecoverRoundTrip(t *testing.T) {
+	db, base := openTestDB(t)
+	val := bytes.Repeat([]byte{'a'}, 700)
+	for i := 0; i < 300; i++ {
+		val[0] = byte(i)
+		if err := db.Set(fmt.Sprintf("player:%d", i), val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 300; i += 3 {
+		if err := db.Del(fmt.Sprintf("player:%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seq := db.seq
+	db = reopen(t, db, base)
+	if db.seq != seq {
+		t.Errorf("seq after recover: got %d want %d", db.seq, seq)
+	}
+	for i := 0; i < 300; i++ {
+		got, ok, err := db.Get(fmt.Sprintf("player:%d", i))
+		if err != nil {
+			t.Fatalf("Get %d: %v", i, err)
+		}
+		if ok != (i%3 != 0) {
+			t.Fatalf("player:%d ok=%v", i, ok)
+		}
+		if ok && (len(got) != len(val) || got[0] != byte(i)) {
+			t.Fatalf("player:%d wrong value", i)
+		}
+	}
+}
+
+func TestRecoverMarksCorruptValue(t *testing.T) {
+	db, base := openTestDB(t)
+	if err := db.Set("good", []byte("fine")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("bad", []byte("rotten")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("after", []byte("still here")); err != nil {
+		t.Fatal(err)
+	}
+	b, _, _ := db.Get("bad")
+	b[0] ^= 0xff
+	db = reopen(t, db, base)
+	if _, _, err := db.Get("bad"); !errors.Is(err, errs.ErrCorrupt) {
+		t.Errorf("bad: want ErrCorrupt, got %v", err)
+	}
+	for _, k := range []string{"good", "after"} {
+		if _, ok, err := db.Get(k); !ok || err != nil {
+			t.Errorf("%s: ok=%v err=%v", k, ok, err)
+		}
+	}
+}
+
+func TestCompactKeepsTombstones(t *testing.T) {
+	db, base := openTestDB(t)
+	val := bytes.Repeat([]byte{'x'}, 1000)
+	if err := db.Set("gone", val); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; db.lastSeg().ID() == 0; i++ {
+		if err := db.Set(fmt.Sprintf("fill%d", i), val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Del("gone"); err != nil {
+		t.Fatal(err)
+	}
+	tomb := db.lastSeg().ID()
+	if _, err := db.appendSeg(); err != nil {
+		t.Fatal(err)
+	}
+	if retired, err := db.compactSeg(tomb); err != nil || !retired {
+		t.Fatalf("compactSeg: retired=%v err=%v", retired, err)
+	}
+	if db.segMgr.Seg(tomb) != nil {
+		t.Fatal("tombstone segment should be retired")
+	}
+	db = reopen(t, db, base)
+	if _, ok, _ := db.Get("gone"); ok {
+		t.Fatal("deleted key resurrected after compaction and recover")
+	}
+}
+
+// writeV1 按 v1 布局在 data 的 off 处写入一条 Put 记录，value 放在 valOff。
+func writeV1(data []byte, off, valOff uint64, key string, val []byte) uint64 {
+	return writeV1Flags(data, off, valOff, consts.FlagPut, key, val)
+}
+
+func writeV1Flags(data []byte, off, valOff uint64, flags uint16, key string, val []byte) uint64 {
+	copy(data[valOff:], val)
+	h := record.Header{
+		Magic:  consts.Magic,
+		Ver:    consts.Version1,
+		Flags:  flags,
+		KeyLen: uint16(len(key)),
+		ValLen: uint32(len(val)),
+		ValOff: valOff,
+	}
+	h.CRC32 = record.CalcCRC(h.Flags, h.KeyLen, h.ValLen, h.ValOff, []byte(key))
+	record.EncodeHeader(data[off:], h)
+	copy(data[off+consts.HeaderSizeV1:], key)
+	return off + consts.HeaderSizeV1 + uint64(len(key))
+}
+
+func TestReadAndUpgradeV1(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "kv.data")
+	data := make([]byte, testSegSize)
+	off := writeV1(data, 0, testSegSize-16, "player:1", []byte("v1-one"))
+	off = writeV1(data, off, testSegSize-32, "player:2", []byte("v1-two"))
+	// v1 已冻结，带 v2 标志位的 v1 记录不合法，解析在此停止。
+	writeV1Flags(data, off, testSegSize-48, consts.FlagPut|consts.FlagTTL, "player:3", []byte("v1-ttl"))
+	if err := os.WriteFile(base+".000", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(base, testSegSize)
+	if err != nil {
+		t.Fatalf("Open v1: %v", err)
+	}
+	got, ok, err := db.Get("player:2")
+	if err != nil || !ok || string(got) != "v1-two" {
+		t.Fatalf("Get v1: %q ok=%v err=%v", got, ok, err)
+	}
+	if _, ok, _ := db.Get("player:3"); ok {
+		t.Error("v1 record with v2 flags was accepted")
+	}
+	if r := db.RecoveryReport().Segments[0].Reason; !strings.Contains(r, "unsupported v1 flags") {
+		t.Errorf("recovery reason %q", r)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := Upgrade(base, testSegSize)
+	if err != nil || n != 1 {
+		t.Fatalf("Upgrade: n=%d err=%v", n, err)
+	}
+	if _, err := os.Stat(base + ".000"); !os.IsNotExist(err) {
+		t.Errorf("v1 segment should be retired, stat err=%v", err)
+	}
+	db, err = Open(base, testSegSize)
+	if err != nil {
+		t.Fatalf("Open upgraded: %v", err)
+	}
+	defer db.Close()
+	if len(db.upgradeCandidates()) != 0 {
+		t.Error("v1 records remain after upgrade")
+	}
+	for k, want := range map[string]string{"player:1": "v1-one", "player:2": "v1-two"} {
+		got, ok, err := db.Get(k)
+		if err != nil || !ok || string(got) != want {
+			t.Errorf("%s after upgrade: %q ok=%v err=%v", k, got, ok, err)
+		}
+	}
+}
+
+// removeHints 删除所有段的 hint 文件，迫使 Recover 解析 log。
+func removeHints(tb testing.TB, db *DB) {
+	tb.Helper()
+	db.hintWG.Wait()
+	for _, seg := range db.segMgr.Segments() {
+		if err := os.Remove(fs.HintPath(db.base, seg.ID())); err != nil && !os.IsNotExist(err) {
+			tb.Fatal(err)
+		}
+	}
+}
+
+func TestRecoverParallelMatchesSequential(t *testing.T) {
+	db, _ := openTestDB(t)
+	val := bytes.Repeat([]byte{'p'}, 1500)
+	for i := 0; i < 400; i++ {
+		key := fmt.Sprintf("k%03d", i%150)
+		var err error
+		switch {
+		case i%13 == 0:
+			err = db.Del(key)
+		case i%31 == 0:
+			err = db.Apply([]BatchOp{{Key: key, Value: val[:i]}, {Key: "batch", Del: true}})
+		case i%37 == 0:
+			err = db.SetWithTTL(key, val, time.Hour)
+		case i%97 == 0:
+			err = db.Set(key, bytes.Repeat([]byte("chunked!"), testSegSize/6))
+		default:
+			err = db.Set(key, val[:200+i])
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(db.segMgr.Segments()); n < 4 {
+		t.Fatalf("only %d segments", n)
+	}
+	seq := db.seq
+
+	run := func(workers int) (map[string]index.Entry, string) {
+		removeHints(t, db)
+		db.recoverWorkers = workers
+		if err := db.Recover(); err != nil {
+			t.Fatal(err)
+		}
+		if db.seq != seq {
+			t.Errorf("workers=%d: seq %d want %d", workers, db.seq, seq)
+		}
+		return indexState(db), segState(db)
+	}
+	wantIdx, wantSegs := run(1)
+	gotIdx, gotSegs := run(8)
+	if gotSegs != wantSegs {
+		t.Errorf("segments:\n got %s\nwant %s", gotSegs, wantSegs)
+	}
+	if len(gotIdx) != len(wantIdx) {
+		t.Fatalf("%d keys in parallel, %d sequential", len(gotIdx), len(wantIdx))
+	}
+	for k, e := range wantIdx {
+		if gotIdx[k] != e {
+			t.Errorf("%s: parallel %+v, sequential %+v", k, gotIdx[k], e)
+		}
+	}
+	for k := range gotIdx {
+		if _, ok, err := db.Get(k); !ok || err != nil {
+			t.Errorf("%s: ok=%v err=%v", k, ok, err)
+		}
+	}
+}
+
+func BenchmarkRecover(b *testing.B) {
+	const segSize = 1 << 20
+	base := filepath.Join(b.TempDir(), "kv.data")
+	db, err := OpenWithOptions(base, Options{SegSize: segSize})
+	if err != nil {
+		b.Fatal(err)
+	}
+	val := bytes.Repeat([]byte{'b'}, 200)
+	for i := 0; len(db.segMgr.Segments()) < 32; i++ {
+		if err := db.Set(fmt.Sprintf("key:%07d", i%200000), val); err != nil {
+			b.Fatal(err)
+		}
+	}
+	removeHints(b, db)
+	if err := db.Close(); err != nil {
+		b.Fatal(err)
+	}
+	ro, err := OpenWithOptions(base, Options{SegSize: segSize, ReadOnly: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ro.Close()
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			ro.recoverWorkers = workers
+			for b.Loop() {
+				if err := ro.Recover(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoveryReport(t *testing.T) {
+	db, base := openTestDB(t)
+	val := bytes.Repeat([]byte{'r'}, 1500)
+	for i := 0; len(db.segMgr.Segments()) < 3; i++ {
+		if err := db.Set(fmt.Sprintf("k%03d", i), val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 活跃段末尾留下一个被撕裂的批次。
+	begin := db.lastSeg().LogEnd()
+	if err := db.Apply([]BatchOp{{Key: "b1", Value: []byte("1")}, {Key: "b2", Value: []byte("2")}}); err != nil {
+		t.Fatal(err)
+	}
+	last := db.lastSeg()
+	commit := last.LogEnd() - consts.HeaderSize
+	clear(last.GetData()[commit:last.LogEnd()])
+	batchBytes := commit - begin
+	first, second := db.segMgr.Segments()[0], db.segMgr.Segments()[1]
+	data := first.GetData()
+	off := first.LogStart()
+	for range 5 {
+		h, _, ok := record.Parse(data, off, first.ValEnd(), first.ID())
+		if !ok {
+			t.Fatal("log too short")
+		}
+		off += h.RecLen()
+	}
+	data[off+consts.HeaderSize] ^= 0xff // 第 6 条记录的 key
+	removeHints(t, db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenWithOptions(base, Options{SegSize: testSegSize, StrictRecovery: true}); !errors.Is(err, errs.ErrCorrupt) {
+		t.Fatalf("strict open: want ErrCorrupt, got %v", err)
+	}
+	if _, err := os.Stat(fs.HintPath(base, second.ID())); !os.IsNotExist(err) {
+		t.Errorf("failed strict open wrote a hint: %v", err)
+	}
+	for round := range 2 {
+		db, err := Open(base, testSegSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rep := db.RecoveryReport()
+		_ = db.Close()
+		if len(rep.Segments) != 3 {
+			t.Fatalf("round %d: %d segments in report", round, len(rep.Segments))
+		}
+		s := rep.Segments[0]
+		if s.FromHint || s.Applied != 5 || s.LogEnd != off || s.Reason != "header checksum mismatch" || s.Discarded == 0 {
+			t.Errorf("round %d: first segment %+v", round, s)
+		}
+		if tr := rep.Truncated(); len(tr) != 1 || tr[0].ID != first.ID() {
+			t.Errorf("round %d: truncated %+v", round, tr)
+		}
+		if s := rep.Segments[1]; s.FromHint != (round == 1) || s.Discarded != 0 || s.Applied == 0 {
+			t.Errorf("round %d: second segment %+v", round, s)
+		}
+		if s := rep.Segments[2]; s.Discarded != 0 || s.Reason != "" || s.DroppedBatches != 1 || s.DroppedBytes != batchBytes {
+			t.Errorf("round %d: active segment %+v, want one dropped batch of %d bytes", round, s, batchBytes)
+		}
+		// 被截断的段不补写 hint，再次打开仍会报告。
+		if _, err := os.Stat(fs.HintPath(base, first.ID())); !os.IsNotExist(err) {
+			t.Errorf("round %d: hint written for truncated segment: %v", round, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"shm_master/internal/index"
+	"sort"
+)
+
+// Scan 按字典序遍历 [start, end) 内的 key 并回调 value，fn 返回 false 时停止。
+// start 为空表示从头开始，end 为空表示不设上界。每个 value 在回调前通过 Get 读取，
+// 因此遍历期间的并发写入对尚未访问到的 key 可见；fn 内可以读写 DB。
+// 哈希索引下会先收集并排序全部匹配的 key。
+func (db *DB) Scan(start, end string, fn func(key string, value []byte) bool) error {
+	if end != "" && start >= end {
+		return nil
+	}
+	if o, ok := db.idx.(index.Ordered); ok {
+		return db.scanOrdered(o, start, end, fn)
+	}
+	var keys []string
+	db.idx.Range(func(key string, _ index.Entry) bool {
+		if key >= start && (end == "" || key < end) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	sort.Strings(keys)
+	_, err := db.visit(keys, fn)
+	return err
+}
+
+// ScanPrefix 按字典序遍历以 prefix 开头的 key，语义同 Scan。
+func (db *DB) ScanPrefix(prefix string, fn func(key string, value []byte) bool) error {
+	return db.Scan(prefix, prefixEnd(prefix), fn)
+}
+
+// scanOrdered 分批从有序索引取 key 并读取 value。
+func (db *DB) scanOrdered(o index.Ordered, start, end string, fn func(key string, value []byte) bool) error {
+	var err error
+	index.AscendKeys(o, start, end, func(keys []string) bool {
+		var more bool
+		more, err = db.visit(keys, fn)
+		return more
+	})
+	return err
+}
+
+// visit 依次读取 keys 并回调 fn，跳过期间已被删除或过期的 key；返回 false 表示 fn 要求停止。
+func (db *DB) visit(keys []string, fn func(key string, value []byte) bool) (bool, error) {
+	for _, key := range keys {
+		v, ok, err := db.Get(key)
+		if err != nil {
+			return false, err
+		}
+		if ok && !fn(key, v) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// prefixEnd 返回大于所有以 prefix 开头的 key 的最小上界；不存在时返回空串（不设上界）。
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
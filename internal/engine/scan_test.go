@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"shm_master/internal/index"
+	"strings"
+	"testing"
+)
+
+func TestScanPrefix(t *testing.T) {
+	for _, kind := range []IndexKind{IndexHash, IndexOrdered} {
+		t.Run(map[IndexKind]string{IndexHash: "hash", IndexOrdered: "ordered"}[kind], func(t *testing.T) {
+			db, err := OpenWithOptions(filepath.Join(t.TempDir(), "kv.data"), Options{SegSize: testSegSize, Index: kind})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			for _, k := range []string{"player:3", "guild:1", "player:1", "player:20", "player;", "player:2"} {
+				if err := db.Set(k, []byte("v-"+k)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := db.Del("player:20"); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			if err := db.ScanPrefix("player:", func(k string, v []byte) bool {
+				if string(v) != "v-"+k {
+					t.Errorf("%s: value %q", k, v)
+				}
+				got = append(got, k)
+				return true
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if s := strings.Join(got, ","); s != "player:1,player:2,player:3" {
+				t.Errorf("ScanPrefix = %s", s)
+			}
+			got = got[:0]
+			if err := db.Scan("guild:1", "player:2", func(k string, _ []byte) bool {
+				got = append(got, k)
+				return len(got) < 2
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if s := strings.Join(got, ","); s != "guild:1,player:1" {
+				t.Errorf("Scan = %s", s)
+			}
+
+			// 超过一批的 key 需要跨批续扫。
+			for i := 0; i < 3*index.KeyBatch; i++ {
+				if err := db.Set(fmt.Sprintf("z:%04d", i), []byte("z")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			n, prev := 0, ""
+			if err := db.ScanPrefix("z:", func(k string, _ []byte) bool {
+				if k <= prev {
+					t.Errorf("out of order: %s after %s", k, prev)
+				}
+				prev = k
+				n++
+				return true
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if n != 3*index.KeyBatch {
+				t.Errorf("ScanPrefix(z:) visited %d keys", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"shm_master/internal/errs"
+	"shm_master/internal/index"
+	"time"
+)
+
+// Snapshot 固定在某个记录序列号上的只读视图。
+// 创建之后被修改的 key 会把修改前的索引项记入 undo，读取时优先使用；
+// 被覆盖或删除的 value 块在快照释放前不会被复用，Get 返回的切片在 Release 之前有效。
+// TTL 按快照创建的时刻判断，快照打开期间到期的 key 在快照中仍然可见。
+type Snapshot struct {
+	db   *DB
+	seq  uint64
+	now  int64                // 创建时刻（UnixNano）
+	undo map[string]undoEntry // 受 db.snapMu 保护
+}
+
+// undoEntry 快照创建时 key 的索引项；ok 为 false 表示当时 key 不存在。
+type undoEntry struct {
+	e  index.Entry
+	ok bool
+}
+
+// deferredFree 因快照而推迟归还的 value 块；seq 为导致释放的记录序列号。
+type deferredFree struct {
+	seq uint64
+	e   index.Entry
+}
+
+// Snapshot 创建一个固定在当前序列号上的快照，用完必须 Release。
+func (db *DB) Snapshot() *Snapshot {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.snapMu.Lock()
+	defer db.snapMu.Unlock()
+	s := &Snapshot{db: db, seq: db.seq, now: time.Now().UnixNano(), undo: make(map[string]undoEntry)}
+	if db.snaps == nil {
+		db.snaps = make(map[*Snapshot]struct{})
+	}
+	db.snaps[s] = struct{}{}
+	db.nsnap.Add(1)
+	return s
+}
+
+// Seq 返回快照固定的记录序列号。
+func (s *Snapshot) Seq() uint64 { return s.seq }
+
+// Release 释放快照，并归还不再被任何快照引用的 value 块。重复调用无副作用。
+func (s *Snapshot) Release() {
+	db := s.db
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.snapMu.Lock()
+	if _, ok := db.snaps[s]; !ok {
+		db.snapMu.Unlock()
+		return
+	}
+	delete(db.snaps, s)
+	db.nsnap.Add(-1)
+	minPin := ^uint64(0)
+	for o := range db.snaps {
+		minPin = min(minPin, o.seq)
+	}
+	db.snapMu.Unlock()
+
+	// seq 不大于所有剩余快照的释放已不被任何快照看到。
+	kept := db.deferred[:0]
+	for _, d := range db.deferred {
+		if d.seq <= minPin {
+			db.releaseBlock(d.e)
+		} else {
+			kept = append(kept, d)
+		}
+	}
+	clear(db.deferred[len(kept):])
+	db.deferred = kept
+}
+
+// entry 返回快照视角下 key 的索引项。
+func (s *Snapshot) entry(key string) (index.Entry, bool) {
+	s.db.snapMu.RLock()
+	defer s.db.snapMu.RUnlock()
+	if u, ok := s.undo[key]; ok {
+		return u.e, u.ok
+	}
+	return s.db.idx.Get(key)
+}
+
+// Get 读取快照视角下 key 的 value（零拷贝，Release 前有效）。
+func (s *Snapshot) Get(key string) ([]byte, bool, error) {
+	if s.released() {
+		return nil, false, errs.ErrClosed
+	}
+	e, ok := s.entry(key)
+	if !ok {
+		return nil, false, nil
+	}
+	return s.db.readEntry(key, e, s.now)
+}
+
+// Range 按快照视角遍历所有 key，fn 返回 false 时停止。
+// 遍历前先在锁内收集索引项，fn 执行期间不阻塞写入。
+func (s *Snapshot) Range(fn func(key string, value []byte) bool) error {
+	if s.released() {
+		return errs.ErrClosed
+	}
+	type kv struct {
+		key string
+		e   index.Entry
+	}
+	var items []kv
+	s.db.snapMu.RLock()
+	s.db.idx.Range(func(key string, e index.Entry) bool {
+		if _, ok := s.undo[key]; !ok {
+			items = append(items, kv{key, e})
+		}
+		return true
+	})
+	for key, u := range s.undo {
+		if u.ok {
+			items = append(items, kv{key, u.e})
+		}
+	}
+	s.db.snapMu.RUnlock()
+	for _, it := range items {
+		v, ok, err := s.db.readEntry(it.key, it.e, s.now)
+		if err != nil {
+			return err
+		}
+		if ok && !fn(it.key, v) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// readEntry 读取快照引用的 value，按 now 判断是否过期；所在段可能已被压缩退役，但快照打开期间映射不会解除。
+func (db *DB) readEntry(key string, e index.Entry, now int64) ([]byte, bool, error) {
+	db.lifeMu.RLock()
+	defer db.lifeMu.RUnlock()
+	if db.segMgr.Last() == nil {
+		return nil, false, errs.ErrClosed
+	}
+	return db.value(key, e, db.segMgr.Mapped(e.SegID), now)
+}
+
+func (s *Snapshot) released() bool {
+	s.db.snapMu.RLock()
+	defer s.db.snapMu.RUnlock()
+	_, ok := s.db.snaps[s]
+	return !ok
+}
+
+// saveUndo 在索引项变化前为每个打开的快照记录 key 的原值。调用方需持有 snapMu 写锁。
+func (db *DB) saveUndo(key string, old index.Entry, hadOld bool) {
+	for s := range db.snaps {
+		if _, ok := s.undo[key]; !ok {
+			s.undo[key] = undoEntry{e: old, ok: hadOld}
+		}
+	}
+}
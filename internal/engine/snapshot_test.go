@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSnapshotIsStable(t *testing.T) {
+	db, _ := openTestDB(t)
+	for k, v := range map[string]string{"a": "a-old", "b": "b-old"} {
+		if err := db.Set(k, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snap := db.Snapshot()
+	pinned, _, _ := snap.Get("a")
+
+	if err := db.Set("a", []byte("a-new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Del("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Apply([]BatchOp{{Key: "c", Value: []byte("c-new")}}); err != nil {
+		t.Fatal(err)
+	}
+	// 同尺寸的写入若能复用旧块，会覆盖快照引用的 value。
+	for i := 0; i < 50; i++ {
+		if err := db.Set(fmt.Sprintf("k%d", i), []byte("xxxxx")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Del(fmt.Sprintf("k%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]string{"a": "a-old", "b": "b-old"}
+	for k, v := range want {
+		got, ok, err := snap.Get(k)
+		if err != nil || !ok || string(got) != v {
+			t.Errorf("snap.Get(%s) = %q ok=%v err=%v, want %q", k, got, ok, err, v)
+		}
+	}
+	if _, ok, _ := snap.Get("c"); ok {
+		t.Error("snapshot sees key written after it")
+	}
+	seen := map[string]string{}
+	if err := snap.Range(func(k string, v []byte) bool {
+		seen[k] = string(v)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Errorf("snap.Range = %v, want %v", seen, want)
+	}
+	if string(pinned) != "a-old" {
+		t.Errorf("pinned slice overwritten: %q", pinned)
+	}
+	if got, _, _ := db.Get("a"); string(got) != "a-new" {
+		t.Errorf("db.Get(a) = %q", got)
+	}
+
+	snap.Release()
+	if len(db.deferred) != 0 {
+		t.Errorf("deferred frees after release: %d", len(db.deferred))
+	}
+	if _, _, err := snap.Get("a"); err == nil {
+		t.Error("Get on released snapshot should fail")
+	}
+}
+
+func TestSnapshotKeepsKeysExpiringLater(t *testing.T) {
+	db, _ := openTestDB(t)
+	if err := db.SetWithTTL("session", []byte("tok"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	snap := db.Snapshot()
+	defer snap.Release()
+	time.Sleep(100 * time.Millisecond)
+	if _, ok, _ := db.Get("session"); ok {
+		t.Fatal("session still visible after its TTL")
+	}
+	if got, ok, err := snap.Get("session"); !ok || err != nil || string(got) != "tok" {
+		t.Errorf("snapshot Get: %q ok=%v err=%v", got, ok, err)
+	}
+	seen := false
+	if err := snap.Range(func(key string, _ []byte) bool {
+		seen = seen || key == "session"
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !seen {
+		t.Error("snapshot Range skipped a key that expired after the snapshot")
+	}
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"shm_master/internal/errs"
+	"shm_master/internal/segment"
+)
+
+// FreeClass freelist 中某一档位的空闲块数。
+type FreeClass = segment.FreeClass
+
+// SegmentStats 单个存活段的空间占用。
+type SegmentStats struct {
+	ID       uint32
+	Size     uint64 // 段文件大小
+	LogStart uint64
+	LogEnd   uint64
+	ValEnd   uint64
+	// Live 仍被索引引用的字节数（value 档位大小 + 记录长度），Dead 为已写入但不再被引用的字节数。
+	Live uint64
+	Dead uint64
+	// Free 为 freelist 中可复用的字节数，FreeClasses 按档位从小到大列出。
+	Free        uint64
+	FreeClasses []FreeClass
+	// Unused 为 log 区与 value 区之间尚未分配的字节数。
+	Unused     uint64
+	Keys       uint64 // log 记录位于本段的存活 key 数
+	Tombstones uint64 // 本段 log 中的墓碑数
+	Retiring   bool   // 正在被压缩
+}
+
+// Stats DB 空间占用的快照，Segments 按段 id 升序排列。
+type Stats struct {
+	Segments []SegmentStats
+	// 以下为所有存活段的合计。
+	Size       uint64
+	Live       uint64
+	Dead       uint64
+	Free       uint64
+	Unused     uint64
+	Keys       uint64
+	Tombstones uint64
+	// MaxSegments 存活段数上限，0 表示不限；段数达到上限且活跃段写满时写入返回 ErrNoSpace。
+	MaxSegments int
+	// Retired 为已退役、文件已删除但仍保持映射的段数，RetiredSize 为它们仍占用的磁盘与地址空间，
+	// 不计入 Size 与 MaxSegments；见 ReleaseRetired。
+	Retired     int
+	RetiredSize uint64
+}
+
+// Stats 返回各段与全局的空间占用。只读取各段的计数器与 freelist 档位计数，不解析 log、不阻塞写入，
+// 可周期性调用；与写入并发时各计数取自略有先后的时刻。
+func (db *DB) Stats() (Stats, error) {
+	db.lifeMu.RLock()
+	defer db.lifeMu.RUnlock()
+	if db.segMgr.Last() == nil {
+		return Stats{}, errs.ErrClosed
+	}
+	st := Stats{MaxSegments: db.segMgr.MaxSegments()}
+	st.Retired, st.RetiredSize = db.segMgr.Retired()
+	for _, seg := range db.segMgr.Segments() {
+		classes, free := seg.FreeClasses()
+		ss := SegmentStats{
+			ID:          seg.ID(),
+			Size:        uint64(seg.DataLen()),
+			LogStart:    seg.LogStart(),
+			LogEnd:      seg.LogEnd(),
+			ValEnd:      seg.ValEnd(),
+			Live:        seg.Live(),
+			Dead:        seg.DeadBytes(),
+			Free:        free,
+			FreeClasses: classes,
+			Keys:        seg.Keys(),
+			Tombstones:  seg.Tombs(),
+			Retiring:    seg.Retiring(),
+		}
+		if ss.ValEnd > ss.LogEnd {
+			ss.Unused = ss.ValEnd - ss.LogEnd
+		}
+		st.Segments = append(st.Segments, ss)
+		st.Size += ss.Size
+		st.Live += ss.Live
+		st.Dead += ss.Dead
+		st.Free += ss.Free
+		st.Unused += ss.Unused
+		st.Keys += ss.Keys
+		st.Tombstones += ss.Tombstones
+	}
+	return st, nil
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	db, base := openTestDB(t)
+	val := bytes.Repeat([]byte{'s'}, 500)
+	for i := 0; i < 200; i++ {
+		if err := db.Set(fmt.Sprintf("k%03d", i), val); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 200; i += 4 {
+		if err := db.Del(fmt.Sprintf("k%03d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Del("never-set"); err != nil {
+		t.Fatal(err)
+	}
+	st, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Keys != 150 || st.Tombstones != 51 {
+		t.Errorf("keys=%d tombstones=%d, want 150 and 51", st.Keys, st.Tombstones)
+	}
+	if len(st.Segments) < 2 {
+		t.Fatalf("only %d segments", len(st.Segments))
+	}
+	// 被删除的 50 个 value 都在 512 字节档位的 freelist 里。
+	if st.Free != 50*512 {
+		t.Errorf("free=%d, want %d", st.Free, 50*512)
+	}
+	var free uint64
+	for _, ss := range st.Segments {
+		for _, fc := range ss.FreeClasses {
+			if fc.Class != 512 {
+				t.Errorf("seg %d: unexpected free class %d", ss.ID, fc.Class)
+			}
+			free += uint64(fc.Class) * uint64(fc.Blocks)
+		}
+		if ss.Live+ss.Dead != ss.LogEnd-ss.LogStart+ss.Size-ss.ValEnd {
+			t.Errorf("seg %d: live %d + dead %d != used bytes", ss.ID, ss.Live, ss.Dead)
+		}
+	}
+	if free != st.Free {
+		t.Errorf("free classes sum to %d, total %d", free, st.Free)
+	}
+
+	db = reopen(t, db, base)
+	got, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Keys != st.Keys || got.Tombstones != st.Tombstones || got.Live != st.Live || got.Free != st.Free {
+		t.Errorf("after reopen: %+v\nbefore: %+v", got, st)
+	}
+}
+
+func TestStatsDoesNotBlockWriters(t *testing.T) {
+	db, _ := openTestDB(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			key := fmt.Sprintf("k%d", i%100)
+			if err := db.Set(key, []byte(key)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		// 写锁被占用时 Stats 仍能返回。
+		db.writeMu.Lock()
+		_, err := db.Stats()
+		db.writeMu.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
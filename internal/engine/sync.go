@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"shm_master/internal/errs"
+	"time"
+)
+
+// SyncPolicy 选择已确认的写入何时刷回磁盘。
+type SyncPolicy int
+
+const (
+	// SyncNever 只在 Sync 与 Close 时刷盘；进程崩溃不丢数据（页缓存仍在），机器掉电可能丢失未刷盘的写入。
+	SyncNever SyncPolicy = iota
+	// SyncInterval 后台每隔 Options.SyncInterval 刷盘一次，掉电最多丢失一个间隔内的写入。
+	SyncInterval
+	// SyncAlways 每次写入在返回前刷盘，最安全也最慢。
+	SyncAlways
+)
+
+// Sync 把此前所有已确认写入所触及的范围刷回磁盘。只读 DB 上为空操作。
+// 刷盘期间持有 writeMu，写入会短暂阻塞，读不受影响。
+func (db *DB) Sync() error {
+	if db.readOnly {
+		return nil
+	}
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if db.segMgr.Last() == nil {
+		return errs.ErrClosed
+	}
+	return db.syncLocked()
+}
+
+// syncLocked 刷盘所有存活段中记录的脏范围；失败时保留未刷的范围供下次重试。调用方需持有 writeMu。
+func (db *DB) syncLocked() error {
+	for _, seg := range db.segMgr.Segments() {
+		spans := seg.TakeDirty()
+		if err := seg.SyncSpans(spans); err != nil {
+			for _, sp := range spans {
+				seg.MarkDirty(sp.Off, sp.Len)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// commitSync 在 SyncAlways 下于写入返回前刷盘。调用方需持有 writeMu。
+func (db *DB) commitSync() error {
+	if db.syncPolicy != SyncAlways {
+		return nil
+	}
+	return db.syncLocked()
+}
+
+// startSyncer 启动 SyncInterval 策略的后台刷盘协程，Close 时停止。
+func (db *DB) startSyncer(interval time.Duration) {
+	db.bgMu.Lock()
+	defer db.bgMu.Unlock()
+	stop := make(chan struct{})
+	db.stopSync = stop
+	db.bgWG.Add(1)
+	go func() {
+		defer db.bgWG.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				if err := db.Sync(); err != nil {
+					db.log.Error("db: background sync failed", "base", db.base, "err", err)
+				}
+			}
+		}
+	}()
+}
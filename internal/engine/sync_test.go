@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"shm_master/internal/errs"
+	"shm_master/internal/segment"
+	"testing"
+	"time"
+)
+
+// dirtyCount 返回各存活段中记录的脏范围总数。
+func dirtyCount(db *DB) int {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	n := 0
+	for _, seg := range db.segMgr.Segments() {
+		spans := seg.TakeDirty()
+		for _, sp := range spans {
+			seg.MarkDirty(sp.Off, sp.Len)
+		}
+		n += len(spans)
+	}
+	return n
+}
+
+func TestSyncPolicies(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenWithOptions(filepath.Join(dir, "bad"), Options{SegSize: testSegSize, Sync: SyncInterval}); !errors.Is(err, errs.ErrBadArgument) {
+		t.Fatalf("SyncInterval without interval: %v", err)
+	}
+
+	db, err := Open(filepath.Join(dir, "never"), testSegSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Set("k", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Apply([]BatchOp{{Key: "a", Value: []byte("1")}, {Key: "k", Del: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if n := dirtyCount(db); n != 0 {
+		t.Errorf("%d dirty spans left after Sync", n)
+	}
+	if err := db.Set("k", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if n := dirtyCount(db); n != 2 { // 一个 value 块加一条记录
+		t.Errorf("SyncNever: %d dirty spans after Set, want 2", n)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := db.Set(fmt.Sprintf("k%d", i%50), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, limit := dirtyCount(db), segment.MaxDirtySpans*len(db.segMgr.Segments()); n > limit {
+		t.Errorf("SyncNever: %d dirty spans after 1000 Sets, want at most %d", n, limit)
+	}
+
+	always, err := OpenWithOptions(filepath.Join(dir, "always"), Options{SegSize: testSegSize, Sync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer always.Close()
+	for i := 0; i < 100; i++ { // 跨段写入
+		if err := always.Set("k", make([]byte, 1024)); err != nil {
+			t.Fatal(err)
+		}
+		if err := always.Del("k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := dirtyCount(always); n != 0 {
+		t.Errorf("SyncAlways: %d dirty spans left", n)
+	}
+
+	bg, err := OpenWithOptions(filepath.Join(dir, "interval"), Options{SegSize: testSegSize, Sync: SyncInterval, SyncInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bg.Close()
+	if err := bg.Set("k", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for dirtyCount(bg) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("background syncer did not flush")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"shm_master/internal/errs"
+	"shm_master/internal/index"
+	"time"
+)
+
+// SetWithTTL 写入 key，并在 ttl 之后过期；过期时间随记录持久化，Recover 后仍然有效。
+func (db *DB) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	if m := db.metrics; m != nil {
+		start := time.Now()
+		err := db.setWithTTL(key, value, ttl)
+		m.observe(opSet, start, err)
+		return err
+	}
+	return db.setWithTTL(key, value, ttl)
+}
+
+func (db *DB) setWithTTL(key string, value []byte, ttl time.Duration) error {
+	if err := db.writable(); err != nil {
+		return err
+	}
+	if len(key) == 0 || len(key) > int(^uint16(0)) {
+		return errs.ErrBadArgument
+	}
+	if len(value) == 0 || len(value) > int(^uint32(0)) || ttl <= 0 {
+		return errs.ErrBadArgument
+	}
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if err := db.putValue(key, value, time.Now().Add(ttl).UnixNano()); err != nil {
+		return err
+	}
+	db.notify(OpSet, key, db.seq)
+	return nil
+}
+
+// Expire 把已存在的 key 的过期时间设为 ttl 之后，返回 key 是否存在。
+// 新的过期时间需要落盘，因此会连同 value 重写一条记录。
+func (db *DB) Expire(key string, ttl time.Duration) (bool, error) {
+	if err := db.writable(); err != nil {
+		return false, err
+	}
+	if len(key) == 0 || len(key) > int(^uint16(0)) || ttl <= 0 {
+		return false, errs.ErrBadArgument
+	}
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	e, ok := db.idx.Get(key)
+	if !ok || e.Expired(time.Now().UnixNano()) {
+		return false, nil
+	}
+	if e.Corrupt {
+		return false, corruptKey(key)
+	}
+	if err := db.rewrite(key, e, time.Now().Add(ttl).UnixNano()); err != nil {
+		return false, err
+	}
+	db.notify(OpSet, key, db.seq)
+	return true, nil
+}
+
+// TTL 返回 key 的剩余存活时间；key 不存在或已过期时 ok 为 false，永不过期时返回 0。
+func (db *DB) TTL(key string) (time.Duration, bool, error) {
+	db.lifeMu.RLock()
+	defer db.lifeMu.RUnlock()
+	if db.segMgr.Last() == nil {
+		return 0, false, errs.ErrClosed
+	}
+	e, ok := db.idx.Get(key)
+	if !ok {
+		return 0, false, nil
+	}
+	if e.ExpireAt == 0 {
+		return 0, true, nil
+	}
+	left := time.Duration(e.ExpireAt - time.Now().UnixNano())
+	if left <= 0 {
+		return 0, false, nil
+	}
+	return left, true, nil
+}
+
+// ReapExpired 为所有已过期的 key 写入墓碑并归还其 value 块，返回清理的 key 数。
+// 与 Compact 一样按 key 逐个持有 writeMu。
+func (db *DB) ReapExpired() (int, error) {
+	if err := db.writable(); err != nil {
+		return 0, err
+	}
+	now := time.Now().UnixNano()
+	var keys []string
+	db.idx.Range(func(key string, e index.Entry) bool {
+		if e.Expired(now) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	n := 0
+	for _, key := range keys {
+		db.writeMu.Lock()
+		var err error
+		// 期间 key 可能已被重新写入，需要再确认一次。
+		if e, ok := db.idx.Get(key); ok && e.Expired(now) {
+			err = db.delLocked(key)
+			if err == nil {
+				db.notify(OpDel, key, db.seq)
+				n++
+			}
+		}
+		db.writeMu.Unlock()
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// StartReaper 启动后台清理：每隔 interval 执行一次 ReapExpired，Close 时停止。
+// 重复调用只会保留第一次启动的清理协程。
+func (db *DB) StartReaper(interval time.Duration) error {
+	if err := db.writable(); err != nil {
+		return err
+	}
+	if interval <= 0 {
+		return errs.ErrBadArgument
+	}
+	db.bgMu.Lock()
+	defer db.bgMu.Unlock()
+	if db.stopReap != nil {
+		return nil
+	}
+	stop := make(chan struct{})
+	db.stopReap = stop
+	db.bgWG.Add(1)
+	go func() {
+		defer db.bgWG.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				if _, err := db.ReapExpired(); err != nil {
+					db.log.Warn("db: background reap failed", "base", db.base, "err", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
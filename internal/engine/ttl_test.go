@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLExpiresAndSurvivesRecover(t *testing.T) {
+	db, base := openTestDB(t)
+	if err := db.SetWithTTL("session", []byte("tok"), 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetWithTTL("match", []byte("lobby"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("plain", []byte("forever")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.Expire("plain", time.Hour); !ok || err != nil {
+		t.Fatalf("Expire(plain): ok=%v err=%v", ok, err)
+	}
+	if ok, _ := db.Expire("missing", time.Hour); ok {
+		t.Error("Expire on missing key reported ok")
+	}
+
+	db = reopen(t, db, base)
+	if left, ok, _ := db.TTL("match"); !ok || left <= 0 || left > time.Hour {
+		t.Errorf("TTL(match) after recover = %v ok=%v", left, ok)
+	}
+	if left, ok, _ := db.TTL("plain"); !ok || left <= 0 {
+		t.Errorf("TTL(plain) after Expire+recover = %v ok=%v", left, ok)
+	}
+	if got, ok, _ := db.Get("plain"); !ok || string(got) != "forever" {
+		t.Errorf("Get(plain) = %q ok=%v", got, ok)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if _, ok, _ := db.Get("session"); ok {
+		t.Error("expired key still visible to Get")
+	}
+	if _, ok, _ := db.TTL("session"); ok {
+		t.Error("expired key still has a TTL")
+	}
+	n, err := db.ReapExpired()
+	if err != nil || n != 1 {
+		t.Fatalf("ReapExpired: n=%d err=%v", n, err)
+	}
+	if _, ok := db.idx.Get("session"); ok {
+		t.Error("reaped key still indexed")
+	}
+	db = reopen(t, db, base)
+	if _, ok := db.idx.Get("session"); ok {
+		t.Error("reaped key came back after recover")
+	}
+	if _, ok, _ := db.Get("match"); !ok {
+		t.Error("unexpired key lost")
+	}
+}
@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"shm_master/consts"
+	"shm_master/internal/record"
+)
+
+// Upgrade 离线把 base 中仍含 v1 记录或没有超级块的段重写为 v2：
+// 先追加一个新的活跃段，再借助压缩逐段搬迁存活数据并退役旧段。返回重写的段数。
+// 调用期间不得有其它进程或 DB 实例打开同一个 base。
+func Upgrade(base string, segSize int64) (int, error) {
+	db, err := Open(base, segSize)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	ids := db.upgradeCandidates()
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	db.writeMu.Lock()
+	_, err = db.appendSeg()
+	db.writeMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, id := range ids {
+		retired, err := db.compactSeg(id)
+		if err != nil {
+			return n, err
+		}
+		if retired {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// upgradeCandidates 返回需要重写为 v2 的段 id。
+func (db *DB) upgradeCandidates() []uint32 {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	var ids []uint32
+	for _, seg := range db.segMgr.Segments() {
+		v1 := seg.Legacy()
+		if !v1 {
+			scanLog(seg, func(h record.Header, _ []byte) bool {
+				v1 = h.Ver == consts.Version1
+				return !v1
+			})
+		}
+		if v1 {
+			ids = append(ids, seg.ID())
+		}
+	}
+	return ids
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// Op 变更事件的类型。
+type Op uint8
+
+const (
+	// OpSet key 被写入（Set、SetWithTTL 或批量写中的 Set）。
+	OpSet Op = iota + 1
+	// OpDel key 被删除（Del、批量写中的 Del，或过期后被清理）。
+	OpDel
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpSet:
+		return "set"
+	case OpDel:
+		return "del"
+	}
+	return "unknown"
+}
+
+// Event 一次已提交的变更，Seq 为对应记录的序列号。
+type Event struct {
+	Key string
+	Op  Op
+	Seq uint64
+}
+
+// WatchBuffer 每个 Watcher 的事件缓冲长度。
+const WatchBuffer = 1024
+
+// Watcher 订阅 key 前缀的变更事件。事件在写入提交、索引更新之后按提交顺序投递；
+// 写者从不等待订阅方：缓冲已满时新事件被丢弃并计入 Dropped，订阅方据此判断是否需要重新读取全量状态。
+type Watcher struct {
+	db      *DB
+	prefix  string
+	ch      chan Event
+	dropped atomic.Uint64
+	closed  bool // 受 db.watchMu 保护
+}
+
+// Watch 订阅以 prefix 开头的 key 的变更，prefix 为空时订阅全部 key。
+// 只报告本 DB 句柄上的写入：只读 DB 的 Refresh 不产生事件。DB 关闭时通道随之关闭。
+func (db *DB) Watch(prefix string) *Watcher {
+	w := &Watcher{db: db, prefix: prefix, ch: make(chan Event, WatchBuffer)}
+	db.lifeMu.RLock()
+	defer db.lifeMu.RUnlock()
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	if db.segMgr.Last() == nil {
+		w.closed = true
+		close(w.ch)
+		return w
+	}
+	if db.watchers == nil {
+		db.watchers = make(map[*Watcher]struct{})
+	}
+	db.watchers[w] = struct{}{}
+	db.nwatch.Add(1)
+	return w
+}
+
+// C 返回事件通道。
+func (w *Watcher) C() <-chan Event { return w.ch }
+
+// Dropped 返回因缓冲已满而丢弃的事件数。
+func (w *Watcher) Dropped() uint64 { return w.dropped.Load() }
+
+// Close 取消订阅并关闭事件通道。重复调用无副作用。
+func (w *Watcher) Close() {
+	w.db.watchMu.Lock()
+	defer w.db.watchMu.Unlock()
+	w.db.unwatch(w)
+}
+
+// unwatch 注销 w 并关闭其通道，调用方需持有 watchMu。
+func (db *DB) unwatch(w *Watcher) {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	delete(db.watchers, w)
+	db.nwatch.Add(-1)
+	close(w.ch)
+}
+
+// closeWatchers 关闭所有订阅，供 Close 调用。
+func (db *DB) closeWatchers() {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	for w := range db.watchers {
+		db.unwatch(w)
+	}
+}
+
+// notify 把一次已提交的变更投递给匹配的订阅方，从不阻塞。调用方需持有 writeMu。
+func (db *DB) notify(op Op, key string, seq uint64) {
+	if db.nwatch.Load() == 0 {
+		return
+	}
+	db.watchMu.RLock()
+	defer db.watchMu.RUnlock()
+	ev := Event{Key: key, Op: op, Seq: seq}
+	for w := range db.watchers {
+		if !strings.HasPrefix(key, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- ev:
+		default:
+			w.dropped.Add(1)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchPrefix(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "kv.data"), testSegSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := db.Watch("player:")
+	if err := db.Set("player:1", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("guild:1", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Del("player:2"); err != nil { // 不存在的 key 不产生事件
+		t.Fatal(err)
+	}
+	if err := db.Apply([]BatchOp{{Key: "player:2", Value: []byte("c")}, {Key: "player:1", Del: true}}); err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{{"player:1", OpSet, 0}, {"player:2", OpSet, 0}, {"player:1", OpDel, 0}}
+	var last uint64
+	for _, ev := range want {
+		got := <-w.C()
+		if got.Key != ev.Key || got.Op != ev.Op || got.Seq <= last {
+			t.Fatalf("event = %+v, want %s %s after seq %d", got, ev.Op, ev.Key, last)
+		}
+		last = got.Seq
+	}
+	select {
+	case ev := <-w.C():
+		t.Fatalf("unexpected event %+v", ev)
+	default:
+	}
+
+	// 缓冲写满后写者不阻塞，多出的事件计入 Dropped。
+	for i := 0; i < WatchBuffer+10; i++ {
+		if err := db.Set("player:x", []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.Dropped() != 10 {
+		t.Errorf("Dropped = %d, want 10", w.Dropped())
+	}
+
+	w2 := db.Watch("")
+	w2.Close()
+	w2.Close()
+	if _, ok := <-w2.C(); ok {
+		t.Error("closed watcher still delivers events")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range w.C() {
+		n++
+	}
+	if n != WatchBuffer {
+		t.Errorf("drained %d buffered events, want %d", n, WatchBuffer)
+	}
+}
+
+// Expire 重写记录时产生 OpSet，压缩把过期 key 写成墓碑时产生 OpDel。
+func TestWatchTTLEvents(t *testing.T) {
+	db, _ := openTestDB(t)
+	w := db.Watch("t:")
+	defer w.Close()
+	if err := db.Set("t:1", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.Expire("t:1", time.Hour); !ok || err != nil {
+		t.Fatalf("Expire: ok=%v err=%v", ok, err)
+	}
+	if err := db.SetWithTTL("t:2", []byte("b"), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	e, _ := db.idx.Get("t:2")
+	if err := db.moveKey("t:2", e.SegID); err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{{"t:1", OpSet, 0}, {"t:1", OpSet, 0}, {"t:2", OpSet, 0}, {"t:2", OpDel, 0}}
+	for _, ev := range want {
+		select {
+		case got := <-w.C():
+			if got.Key != ev.Key || got.Op != ev.Op {
+				t.Fatalf("event = %+v, want %s %s", got, ev.Op, ev.Key)
+			}
+		default:
+			t.Fatalf("missing %s %s", ev.Op, ev.Key)
+		}
+	}
+}
@@ -7,4 +7,7 @@ var (
 	ErrBadArgument = errors.New("db: bad argument")
 	ErrClosed      = errors.New("db: closed")
 	ErrCorrupt     = errors.New("db: corrupt")
+	ErrMismatch    = errors.New("db: segment mismatch")
+	ErrLocked      = errors.New("db: locked by another writer")
+	ErrReadOnly    = errors.New("db: read-only")
 )
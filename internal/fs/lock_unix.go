@@ -0,0 +1,47 @@
+//go:build unix
+
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"shm_master/internal/errs"
+
+	"golang.org/x/sys/unix"
+)
+
+// Lock 基于 flock 的建议锁，持有期间锁文件保持打开。
+type Lock struct {
+	f *os.File
+}
+
+// LockFile 对 path 加非阻塞的独占 flock，文件不存在时以 perm 创建；已被其它打开者持有时返回 ErrLocked。
+// flock 绑定在打开的文件上，同一进程内重复 Open 同一个 base 也会失败。
+func LockFile(path string, perm os.FileMode) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, perm)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", errs.ErrLocked, path)
+		}
+		return nil, err
+	}
+	return &Lock{f: f}, nil
+}
+
+// Unlock 释放锁并关闭锁文件；锁文件本身保留，删除它会让并发的加锁者锁住不同的 inode。
+func (l *Lock) Unlock() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	err := unix.Flock(int(l.f.Fd()), unix.LOCK_UN)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
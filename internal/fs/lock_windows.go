@@ -0,0 +1,16 @@
+//go:build windows
+
+package fs
+
+import "os"
+
+// Lock windows 下不加锁（mmap 本身也不受支持）。
+type Lock struct{}
+
+func LockFile(path string, perm os.FileMode) (*Lock, error) {
+	return &Lock{}, nil
+}
+
+func (l *Lock) Unlock() error {
+	return nil
+}
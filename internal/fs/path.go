@@ -1,8 +1,111 @@
 package fs
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
 
 // SegPath 返回 base 对应 id 的 segment 文件路径。
 func SegPath(base string, id uint32) string {
 	return fmt.Sprintf("%s.%03d", base, id)
 }
+
+// ListSegIDs 列出目录中属于 base 的全部段 id（升序），允许中间有空洞。
+func ListSegIDs(base string) ([]uint32, error) {
+	dir := filepath.Dir(base)
+	prefix := filepath.Base(base) + "."
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []uint32
+	for _, ent := range ents {
+		name := ent.Name()
+		if ent.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		id, ok := parseSegSuffix(name[len(prefix):])
+		if !ok {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// parseSegSuffix 解析 "000" 形式的段后缀，至少三位纯数字。
+func parseSegSuffix(s string) (uint32, bool) {
+	if len(s) < 3 {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+	}
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(v), true
+}
+
+// HintPath 返回 base 对应 id 的段 hint 文件路径。
+func HintPath(base string, id uint32) string {
+	return SegPath(base, id) + ".hint"
+}
+
+// ManifestPath 返回 base 对应的 manifest 文件路径。
+func ManifestPath(base string) string {
+	return base + ".MANIFEST"
+}
+
+// LockPath 返回 base 对应的写者锁文件路径。
+func LockPath(base string) string {
+	return base + ".LOCK"
+}
+
+// SyncDir fsync 目录，使其中的创建/重命名/删除落盘。
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// WriteAtomic 原子替换 path：以 perm 写临时文件 path.tmp、fsync、rename，再 fsync 目录。
+func WriteAtomic(path string, b []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return SyncDir(filepath.Dir(path))
+}
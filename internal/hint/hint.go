@@ -0,0 +1,159 @@
+// Package hint 读写已封存段的 hint 文件：按 log 顺序记下重放该段时生效的每个操作，
+// Open 时据此重建索引，无需逐条解析 log 与校验 value。
+package hint
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"shm_master/internal/errs"
+	"shm_master/internal/fs"
+	"shm_master/internal/record"
+)
+
+const (
+	magic   = uint32(0x4B564854) // 'KVHT'
+	version = uint16(1)
+
+	// 文件头：magic(4) ver(2) rsv(2) segID(4) count(4) createdAt(8) logEnd(8) valEnd(8) maxSeq(8)。
+	headSize = 4 + 2 + 2 + 4 + 4 + 8 + 8 + 8 + 8
+	// 每个操作：drop(1) rsv(1) ver(2) flags(2) keyLen(2) valLen(4) valSeg(4) valOff(8) valCRC(4) seq(8) ext(8)，随后是 key。
+	opSize = 1 + 1 + 2 + 2 + 2 + 4 + 4 + 8 + 4 + 8 + 8
+	// 文件末尾为覆盖此前全部字节的 CRC32C。
+	crcSize = 4
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Op 重放时生效的一个操作。Drop 为 false 时按记录 H 应用到索引；
+// 为 true 时 H 是被丢弃批次中的 Put，只需归还其 value 块。
+type Op struct {
+	Drop bool
+	H    record.Header
+	Key  string
+}
+
+// Hint 一个已封存段的重放结果。
+type Hint struct {
+	SegID     uint32
+	CreatedAt int64 // 段超级块的创建时间，用于识别同 id 的不同段文件
+	LogEnd    uint64
+	ValEnd    uint64 // 本段 log 中 value 的最低偏移，不含其它段记录的分块
+	MaxSeq    uint64
+	Ops       []Op
+}
+
+// Encode 编码 h。
+func Encode(h *Hint) []byte {
+	n := headSize + crcSize
+	for _, op := range h.Ops {
+		n += opSize + len(op.Key)
+	}
+	b := make([]byte, headSize, n)
+	binary.LittleEndian.PutUint32(b[0:4], magic)
+	binary.LittleEndian.PutUint16(b[4:6], version)
+	binary.LittleEndian.PutUint32(b[8:12], h.SegID)
+	binary.LittleEndian.PutUint32(b[12:16], uint32(len(h.Ops)))
+	binary.LittleEndian.PutUint64(b[16:24], uint64(h.CreatedAt))
+	binary.LittleEndian.PutUint64(b[24:32], h.LogEnd)
+	binary.LittleEndian.PutUint64(b[32:40], h.ValEnd)
+	binary.LittleEndian.PutUint64(b[40:48], h.MaxSeq)
+	var p [opSize]byte
+	for _, op := range h.Ops {
+		p[0] = 0
+		if op.Drop {
+			p[0] = 1
+		}
+		binary.LittleEndian.PutUint16(p[2:4], op.H.Ver)
+		binary.LittleEndian.PutUint16(p[4:6], op.H.Flags)
+		binary.LittleEndian.PutUint16(p[6:8], uint16(len(op.Key)))
+		binary.LittleEndian.PutUint32(p[8:12], op.H.ValLen)
+		binary.LittleEndian.PutUint32(p[12:16], op.H.ValSeg)
+		binary.LittleEndian.PutUint64(p[16:24], op.H.ValOff)
+		binary.LittleEndian.PutUint32(p[24:28], op.H.ValCRC)
+		binary.LittleEndian.PutUint64(p[28:36], op.H.Seq)
+		binary.LittleEndian.PutUint64(p[36:44], op.H.Ext)
+		b = append(b, p[:]...)
+		b = append(b, op.Key...)
+	}
+	return binary.LittleEndian.AppendUint32(b, crc32.Checksum(b, castagnoli))
+}
+
+// Decode 解码并校验 hint；校验失败时返回 ErrCorrupt。
+func Decode(b []byte) (*Hint, error) {
+	if len(b) < headSize+crcSize {
+		return nil, fmt.Errorf("%w: hint too short", errs.ErrCorrupt)
+	}
+	body := b[:len(b)-crcSize]
+	if crc32.Checksum(body, castagnoli) != binary.LittleEndian.Uint32(b[len(body):]) {
+		return nil, fmt.Errorf("%w: hint checksum mismatch", errs.ErrCorrupt)
+	}
+	if binary.LittleEndian.Uint32(b[0:4]) != magic {
+		return nil, fmt.Errorf("%w: bad hint magic", errs.ErrCorrupt)
+	}
+	if v := binary.LittleEndian.Uint16(b[4:6]); v != version {
+		return nil, fmt.Errorf("%w: hint version %d", errs.ErrMismatch, v)
+	}
+	h := &Hint{
+		SegID:     binary.LittleEndian.Uint32(b[8:12]),
+		CreatedAt: int64(binary.LittleEndian.Uint64(b[16:24])),
+		LogEnd:    binary.LittleEndian.Uint64(b[24:32]),
+		ValEnd:    binary.LittleEndian.Uint64(b[32:40]),
+		MaxSeq:    binary.LittleEndian.Uint64(b[40:48]),
+	}
+	count := binary.LittleEndian.Uint32(b[12:16])
+	if uint64(count)*opSize > uint64(len(body)-headSize) {
+		return nil, fmt.Errorf("%w: hint op count %d", errs.ErrCorrupt, count)
+	}
+	h.Ops = make([]Op, count)
+	rest := body[headSize:]
+	for i := range h.Ops {
+		if len(rest) < opSize {
+			return nil, fmt.Errorf("%w: hint truncated", errs.ErrCorrupt)
+		}
+		p := rest[:opSize]
+		keyLen := int(binary.LittleEndian.Uint16(p[6:8]))
+		if len(rest) < opSize+keyLen {
+			return nil, fmt.Errorf("%w: hint truncated", errs.ErrCorrupt)
+		}
+		h.Ops[i] = Op{
+			Drop: p[0] == 1,
+			H: record.Header{
+				Ver:    binary.LittleEndian.Uint16(p[2:4]),
+				Flags:  binary.LittleEndian.Uint16(p[4:6]),
+				KeyLen: uint16(keyLen),
+				ValLen: binary.LittleEndian.Uint32(p[8:12]),
+				ValSeg: binary.LittleEndian.Uint32(p[12:16]),
+				ValOff: binary.LittleEndian.Uint64(p[16:24]),
+				ValCRC: binary.LittleEndian.Uint32(p[24:28]),
+				Seq:    binary.LittleEndian.Uint64(p[28:36]),
+				Ext:    binary.LittleEndian.Uint64(p[36:44]),
+			},
+			Key: string(rest[opSize : opSize+keyLen]),
+		}
+		rest = rest[opSize+keyLen:]
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing bytes in hint", errs.ErrCorrupt)
+	}
+	return h, nil
+}
+
+// Load 读取并解码 hint 文件；文件不存在时返回的错误满足 os.IsNotExist。
+func Load(path string) (*Hint, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := Decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, path)
+	}
+	return h, nil
+}
+
+// Save 原子写入 hint 文件，见 fs.WriteAtomic。
+func Save(path string, h *Hint, perm os.FileMode) error {
+	return fs.WriteAtomic(path, Encode(h), perm)
+}
@@ -1,16 +1,68 @@
 package index
 
-// Entry 索引项：key 对应 value 所在 segment 与偏移。
+// Entry 索引项：key 对应 value 所在 segment 与偏移，以及记录所在的 log 段。
 type Entry struct {
 	SegID  uint32
 	ValOff uint64
 	ValLen uint32
+	LogSeg uint32
+	// ValCRC 为 value 的 CRC32C，HasCRC 为 false 时（旧记录）无意义。
+	ValCRC uint32
+	HasCRC bool
+	// Corrupt 表示 Recover 时 value 校验失败，Get 返回 ErrCorrupt。
+	Corrupt bool
+	// Unverified 表示 value 来自 hint、打开时未校验，Get 时总是校验。
+	Unverified bool
+	// Seq 为写入该值的记录序列号（v1 记录为 0）。
+	Seq uint64
+	// ExpireAt 为过期时间（Unix 纳秒），0 表示永不过期。
+	ExpireAt int64
+	// Chunked 为 true 时 SegID/ValOff/ValLen 指向分块清单而不是 value 本身。
+	Chunked bool
 }
 
-// Index 键值索引接口：Get/Set/Del。
+// Expired 报告 e 在 now（Unix 纳秒）时是否已过期。
+func (e Entry) Expired(now int64) bool {
+	return e.ExpireAt != 0 && now >= e.ExpireAt
+}
+
+// Index 键值索引接口：Get/Set/Del/Range。
 type Index interface {
 	Get(key string) (Entry, bool)
 	Set(key string, e Entry)
 	Del(key string)
 	Clear()
+	// Range 遍历所有索引项，fn 返回 false 时停止；fn 内不得修改索引。
+	Range(fn func(key string, e Entry) bool)
+	// RangeKeys 分批遍历所有 key，fn 执行期间不持有索引锁，可以修改索引；
+	// keys 在 fn 返回后会被复用。遍历开始前已存在且期间未被删除的 key 恰好出现一次，
+	// 期间新增或删除的 key 可能出现也可能不出现。
+	RangeKeys(fn func(keys []string) bool)
+}
+
+// Ordered 按 key 字典序组织的 Index，支持范围遍历。
+type Ordered interface {
+	Index
+	// AscendRange 按字典序遍历 [start, end) 内的索引项，end 为空表示不设上界；fn 内不得修改索引。
+	AscendRange(start, end string, fn func(key string, e Entry) bool)
+}
+
+// KeyBatch AscendKeys 每批交给回调的 key 数。
+const KeyBatch = 128
+
+// AscendKeys 按字典序分批取出 o 中 [start, end) 内的 key 交给 fn，fn 执行期间不持有索引锁；
+// 下一批从上一批最后一个 key 之后继续，因此每个 key 至多出现一次。
+func AscendKeys(o Ordered, start, end string, fn func(keys []string) bool) {
+	keys := make([]string, 0, KeyBatch)
+	for {
+		keys = keys[:0]
+		o.AscendRange(start, end, func(key string, _ Entry) bool {
+			keys = append(keys, key)
+			return len(keys) < KeyBatch
+		})
+		if len(keys) == 0 || !fn(keys) || len(keys) < KeyBatch {
+			return
+		}
+		start = keys[len(keys)-1] + "\x00"
+	}
 }
@@ -1,9 +1,6 @@
 package index
 
-import (
-	"shm_master/consts"
-	"sync"
-)
+import "sync"
 
 type shard struct {
 	rw  sync.RWMutex
@@ -25,7 +22,7 @@ func NewSharded(shardNum int) *Sharded {
 }
 
 func (s *Sharded) shard(key string) *shard {
-	i := Str2Int(key, consts.ShardSize)
+	i := Str2Int(key, uint32(len(s.shards)))
 	return &s.shards[i]
 }
 
@@ -61,3 +58,34 @@ func (s *Sharded) Clear() {
 		sh.rw.Unlock()
 	}
 }
+
+func (s *Sharded) Range(fn func(key string, e Entry) bool) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.rw.RLock()
+		for k, e := range sh.idx {
+			if !fn(k, e) {
+				sh.rw.RUnlock()
+				return
+			}
+		}
+		sh.rw.RUnlock()
+	}
+}
+
+// RangeKeys 逐个分片复制 key 后回调。
+func (s *Sharded) RangeKeys(fn func(keys []string) bool) {
+	var keys []string
+	for i := range s.shards {
+		sh := &s.shards[i]
+		keys = keys[:0]
+		sh.rw.RLock()
+		for k := range sh.idx {
+			keys = append(keys, k)
+		}
+		sh.rw.RUnlock()
+		if len(keys) > 0 && !fn(keys) {
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package index
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+const (
+	slMaxLevel = 24
+	slP        = 4 // 每层晋升概率 1/slP
+)
+
+type slNode struct {
+	key  string
+	e    Entry
+	next []*slNode
+}
+
+// SkipList 按 key 字典序组织的跳表 Index，支持范围遍历；整体由一把读写锁保护。
+type SkipList struct {
+	mu    sync.RWMutex
+	head  *slNode
+	level int
+	n     int
+}
+
+// NewSkipList 创建空跳表。
+func NewSkipList() *SkipList {
+	return &SkipList{head: &slNode{next: make([]*slNode, slMaxLevel)}, level: 1}
+}
+
+func randomLevel() int {
+	lvl := 1
+	for lvl < slMaxLevel && rand.IntN(slP) == 0 {
+		lvl++
+	}
+	return lvl
+}
+
+// seek 返回第一个 key >= key 的节点；update 非 nil 时记录每层的前驱。调用方需持有锁。
+func (s *SkipList) seek(key string, update []*slNode) *slNode {
+	x := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x.next[0]
+}
+
+func (s *SkipList) Get(key string) (Entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if x := s.seek(key, nil); x != nil && x.key == key {
+		return x.e, true
+	}
+	return Entry{}, false
+}
+
+func (s *SkipList) Set(key string, e Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var update [slMaxLevel]*slNode
+	if x := s.seek(key, update[:]); x != nil && x.key == key {
+		x.e = e
+		return
+	}
+	lvl := randomLevel()
+	for i := s.level; i < lvl; i++ {
+		update[i] = s.head
+	}
+	if lvl > s.level {
+		s.level = lvl
+	}
+	x := &slNode{key: key, e: e, next: make([]*slNode, lvl)}
+	for i := 0; i < lvl; i++ {
+		x.next[i] = update[i].next[i]
+		update[i].next[i] = x
+	}
+	s.n++
+}
+
+func (s *SkipList) Del(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var update [slMaxLevel]*slNode
+	x := s.seek(key, update[:])
+	if x == nil || x.key != key {
+		return
+	}
+	for i := 0; i < len(x.next); i++ {
+		update[i].next[i] = x.next[i]
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+	s.n--
+}
+
+func (s *SkipList) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.head.next)
+	s.level = 1
+	s.n = 0
+}
+
+// Len 返回索引项数量。
+func (s *SkipList) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.n
+}
+
+// Range 按字典序遍历所有索引项。
+func (s *SkipList) Range(fn func(key string, e Entry) bool) {
+	s.AscendRange("", "", fn)
+}
+
+// RangeKeys 按字典序分批遍历所有 key。
+func (s *SkipList) RangeKeys(fn func(keys []string) bool) {
+	AscendKeys(s, "", "", fn)
+}
+
+// AscendRange 按字典序遍历 [start, end) 内的索引项，end 为空表示不设上界。
+func (s *SkipList) AscendRange(start, end string, fn func(key string, e Entry) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for x := s.seek(start, nil); x != nil; x = x.next[0] {
+		if end != "" && x.key >= end {
+			return
+		}
+		if !fn(x.key, x.e) {
+			return
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestSkipListOrder(t *testing.T) {
+	s := NewSkipList()
+	var want []string
+	for i := 0; i < 500; i++ {
+		k := fmt.Sprintf("k%03d", (i*7)%500)
+		s.Set(k, Entry{ValLen: uint32(i)})
+	}
+	for i := 0; i < 500; i += 2 {
+		s.Del(fmt.Sprintf("k%03d", i))
+	}
+	for i := 1; i < 500; i += 2 {
+		want = append(want, fmt.Sprintf("k%03d", i))
+	}
+	sort.Strings(want)
+	if s.Len() != len(want) {
+		t.Fatalf("Len = %d, want %d", s.Len(), len(want))
+	}
+	var got []string
+	s.Range(func(k string, _ Entry) bool {
+		got = append(got, k)
+		return true
+	})
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatal("Range not in key order")
+	}
+	got = got[:0]
+	s.AscendRange("k100", "k110", func(k string, _ Entry) bool {
+		got = append(got, k)
+		return true
+	})
+	if fmt.Sprint(got) != "[k101 k103 k105 k107 k109]" {
+		t.Errorf("AscendRange = %v", got)
+	}
+	if _, ok := s.Get("k100"); ok {
+		t.Error("deleted key still present")
+	}
+	if e, ok := s.Get("k101"); !ok || e.ValLen == 0 {
+		t.Error("Get(k101) failed")
+	}
+}
@@ -0,0 +1,422 @@
+// Package inspect 离线解码段文件：列出段与超级块，逐条解析 log 记录并校验 value，
+// 还原 value 区的块布局。只以只读方式映射文件，不加写者锁，也不依赖索引。
+package inspect
+
+import (
+	"fmt"
+	"os"
+	"shm_master/consts"
+	"shm_master/internal/fs"
+	"shm_master/internal/manifest"
+	"shm_master/internal/mmap"
+	"shm_master/internal/record"
+	"shm_master/internal/segment"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// value 校验结果。
+const (
+	CRCOK      = "ok"
+	CRCBad     = "bad"
+	CRCNone    = "none"    // v1 记录没有 value 校验和
+	CRCMissing = "missing" // value 所在段不存在
+	CRCRange   = "range"   // value 超出所在段
+)
+
+// Report 一个库的全部段。
+type Report struct {
+	Base     string     `json:"base"`
+	DBID     string     `json:"db_id,omitempty"`
+	Manifest bool       `json:"manifest"` // 是否存在 manifest
+	Segments []*Segment `json:"segments"`
+}
+
+// Super 段超级块。
+type Super struct {
+	Version   uint16    `json:"version"`
+	SegSize   int64     `json:"seg_size"`
+	SegID     uint32    `json:"seg_id"`
+	CreatedAt time.Time `json:"created_at"`
+	DBID      string    `json:"db_id"`
+}
+
+// Segment 一个段文件的解析结果。
+type Segment struct {
+	ID    uint32 `json:"id"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	State string `json:"state"` // manifest 中的状态，未登记时为 "unlisted"
+	// Legacy 为没有超级块的旧格式段；SuperErr 非空时超级块损坏，Super 为 nil。
+	Legacy   bool     `json:"legacy,omitempty"`
+	Super    *Super   `json:"super,omitempty"`
+	SuperErr string   `json:"super_err,omitempty"`
+	LogStart uint64   `json:"log_start"`
+	LogEnd   uint64   `json:"log_end"`
+	ValEnd   uint64   `json:"val_end"`
+	Records  []Record `json:"records"`
+	// Stop 非 nil 时 log 在非法记录处停止解析，其后的内容被 Recover 丢弃。
+	Stop   *Stop   `json:"stop,omitempty"`
+	Blocks []Block `json:"blocks,omitempty"`
+}
+
+// Record 一条 log 记录。
+type Record struct {
+	Off      uint64 `json:"off"`
+	Len      uint64 `json:"len"`
+	Ver      uint16 `json:"ver"`
+	Op       string `json:"op"`
+	Flags    string `json:"flags,omitempty"` // 逗号分隔的 ttl、chunked
+	Seq      uint64 `json:"seq"`
+	Key      string `json:"key,omitempty"`
+	ValSeg   uint32 `json:"val_seg,omitempty"`
+	ValOff   uint64 `json:"val_off,omitempty"`
+	ValLen   uint32 `json:"val_len,omitempty"`
+	ExpireAt int64  `json:"expire_at,omitempty"`
+	Ext      uint64 `json:"ext,omitempty"` // Begin 的操作数或 Commit 对应的 Begin 序列号
+	CRC      string `json:"crc,omitempty"` // Put 记录的 value 校验结果
+	// Live 为 true 时该 Put 是其 key 序列号最大的记录；被覆盖的旧记录的块可能已被复用，CRC 不符属正常。
+	Live bool `json:"live,omitempty"`
+	// ChunkCRC 为分块 value 各分块的校验结果，清单无法解码时为 "bad"。
+	ChunkCRC string `json:"chunk_crc,omitempty"`
+}
+
+// Stop log 停止解析的位置与原因。
+type Stop struct {
+	Off       uint64 `json:"off"`
+	Reason    string `json:"reason"`
+	Discarded uint64 `json:"discarded"` // 停止处到 value 区之前最后一个非零字节的字节数
+}
+
+// Block value 区中被记录引用的一块，或两块之间未被任何记录引用的空隙。
+type Block struct {
+	Off  uint64 `json:"off"`
+	Len  uint64 `json:"len"`  // 按档位对齐后的大小
+	Kind string `json:"kind"` // value、chunks（分块清单）、chunk 或 unreferenced
+	Refs []Ref  `json:"refs,omitempty"`
+}
+
+// Ref 引用某块的记录。
+type Ref struct {
+	Seg uint32 `json:"seg"` // 记录所在段
+	Off uint64 `json:"off"` // 记录在段内的偏移
+	Key string `json:"key"`
+	Seq uint64 `json:"seq"`
+}
+
+// Options 控制解析范围。
+type Options struct {
+	// Seg 非 nil 时只输出该段；其它段仍会被映射，用于校验跨段 value 与还原布局。
+	Seg *uint32
+	// Layout 为 true 时还原 value 区布局。
+	Layout bool
+}
+
+// Inspect 解析 base 的全部段文件。
+func Inspect(base string, opts Options) (*Report, error) {
+	ids, err := fs.ListSegIDs(base)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no segment files for %s", base)
+	}
+	rep := &Report{Base: base}
+	states := make(map[uint32]string)
+	if mf, err := manifest.Load(fs.ManifestPath(base)); err == nil {
+		rep.Manifest = true
+		rep.DBID = mf.DBID
+		for _, si := range mf.Segs {
+			states[si.ID] = string(si.State)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	files := make(map[uint32][]byte, len(ids))
+	defer func() {
+		for _, data := range files {
+			_ = mmap.Unmap(data)
+		}
+	}()
+	for _, id := range ids {
+		data, err := mapFile(fs.SegPath(base, id))
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			files[id] = data
+		}
+	}
+
+	for _, id := range ids {
+		seg := &Segment{ID: id, Path: fs.SegPath(base, id), Size: int64(len(files[id])), State: states[id]}
+		if seg.State == "" {
+			seg.State = "unlisted"
+		}
+		readSuper(seg, files[id])
+		walk(seg, files)
+		rep.Segments = append(rep.Segments, seg)
+	}
+	markLive(rep.Segments)
+	if opts.Layout {
+		layout(rep.Segments, files)
+	}
+	if opts.Seg != nil {
+		i := slices.IndexFunc(rep.Segments, func(s *Segment) bool { return s.ID == *opts.Seg })
+		if i < 0 {
+			return nil, fmt.Errorf("segment %d not found", *opts.Seg)
+		}
+		rep.Segments = rep.Segments[i : i+1]
+	}
+	return rep, nil
+}
+
+// mapFile 只读映射 path，空文件返回 nil。
+func mapFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() == 0 {
+		return nil, nil
+	}
+	return mmap.MapReadOnly(f.Fd(), int(st.Size()))
+}
+
+func readSuper(seg *Segment, data []byte) {
+	sb, ok, corrupt := segment.DecodeSuper(data)
+	switch {
+	case !ok:
+		seg.Legacy = true
+		return
+	case corrupt:
+		seg.SuperErr = "superblock checksum mismatch"
+	case sb.SegID != seg.ID:
+		seg.SuperErr = fmt.Sprintf("superblock segment id %d", sb.SegID)
+	case sb.SegSize != seg.Size:
+		seg.SuperErr = fmt.Sprintf("superblock segment size %d, file size %d", sb.SegSize, seg.Size)
+	}
+	if !corrupt {
+		seg.Super = &Super{
+			Version:   sb.Version,
+			SegSize:   sb.SegSize,
+			SegID:     sb.SegID,
+			CreatedAt: time.Unix(0, sb.CreatedAt).UTC(),
+			DBID:      sb.DBID.String(),
+		}
+	}
+	seg.LogStart = consts.SuperSize
+}
+
+// walk 按 Recover 的规则解析 seg 的 log：value 区的下界随本段 value 逐步压低，
+// 记录头不合法或本段 value 与 log 重叠时停止。
+func walk(seg *Segment, files map[uint32][]byte) {
+	data := files[seg.ID]
+	off, limit := seg.LogStart, uint64(len(data))
+	for {
+		h, key, ok := record.Parse(data, off, limit, seg.ID)
+		if !ok {
+			if reason := record.Diagnose(data, off, limit); reason != "" {
+				seg.Stop = &Stop{Off: off, Reason: reason}
+			}
+			break
+		}
+		recLen := h.RecLen()
+		if h.Op() == consts.FlagPut && h.ValSeg == seg.ID {
+			end := h.ValOff + uint64(h.ValLen)
+			if end < h.ValOff || end > uint64(len(data)) || h.ValOff < off+recLen {
+				seg.Stop = &Stop{Off: off, Reason: "value range overlaps log"}
+				break
+			}
+			limit = min(limit, h.ValOff)
+		}
+		seg.Records = append(seg.Records, describe(off, h, key, files))
+		off += recLen
+	}
+	seg.LogEnd, seg.ValEnd = off, limit
+	if seg.Stop != nil {
+		seg.Stop.Discarded = nonZeroLen(data[off:limit])
+	}
+}
+
+// nonZeroLen 返回 b 去掉末尾零字节后的长度。
+func nonZeroLen(b []byte) uint64 {
+	n := len(b)
+	for n > 0 && b[n-1] == 0 {
+		n--
+	}
+	return uint64(n)
+}
+
+// describe 把合法记录转换为 Record，并校验 Put 记录的 value。
+func describe(off uint64, h record.Header, key []byte, files map[uint32][]byte) Record {
+	r := Record{Off: off, Len: h.RecLen(), Ver: h.Ver, Op: opName(h.Op()), Seq: h.Seq, Key: string(key)}
+	var flags []string
+	if h.Flags&consts.FlagTTL != 0 {
+		flags = append(flags, "ttl")
+	}
+	if h.Chunked() {
+		flags = append(flags, "chunked")
+	}
+	r.Flags = strings.Join(flags, ",")
+	switch h.Op() {
+	case consts.FlagPut:
+		r.ValSeg, r.ValOff, r.ValLen, r.ExpireAt = h.ValSeg, h.ValOff, h.ValLen, h.ExpireAt()
+		val, crc := value(files, h.ValSeg, h.ValOff, uint64(h.ValLen))
+		r.CRC = crc
+		if crc == CRCOK && !h.HasValCRC() {
+			r.CRC = CRCNone
+		} else if crc == CRCOK && record.ValueCRC(val) != h.ValCRC {
+			r.CRC = CRCBad
+		}
+		if h.Chunked() && val != nil {
+			r.ChunkCRC = chunkCRC(files, val)
+		}
+	case consts.FlagBegin, consts.FlagCommit:
+		r.Ext = h.Ext
+	}
+	return r
+}
+
+// markLive 标出每个 key 序列号最大的 Put 记录。不区分批次是否提交，只用于阅读。
+func markLive(segs []*Segment) {
+	type pos struct {
+		seq uint64
+		r   *Record
+	}
+	latest := make(map[string]pos)
+	for _, s := range segs {
+		for i := range s.Records {
+			r := &s.Records[i]
+			if r.Op != "put" && r.Op != "del" {
+				continue
+			}
+			if p, ok := latest[r.Key]; !ok || r.Seq >= p.seq {
+				latest[r.Key] = pos{r.Seq, r}
+			}
+		}
+	}
+	for _, p := range latest {
+		p.r.Live = p.r.Op == "put"
+	}
+}
+
+// value 返回段 id 中 [off, off+n) 的内容；取不到时返回 nil 与原因。
+func value(files map[uint32][]byte, id uint32, off, n uint64) ([]byte, string) {
+	data, ok := files[id]
+	if !ok {
+		return nil, CRCMissing
+	}
+	if off+n < off || off+n > uint64(len(data)) {
+		return nil, CRCRange
+	}
+	return data[off : off+n], CRCOK
+}
+
+// chunkCRC 校验分块清单 manifest 列出的各分块，返回第一个不是 ok 的结果。
+func chunkCRC(files map[uint32][]byte, manifest []byte) string {
+	chunks, _, ok := record.DecodeChunks(manifest)
+	if !ok {
+		return CRCBad
+	}
+	for _, c := range chunks {
+		val, crc := value(files, c.Seg, c.Off, uint64(c.Len))
+		if crc != CRCOK {
+			return crc
+		}
+		if record.ValueCRC(val) != c.CRC {
+			return CRCBad
+		}
+	}
+	return CRCOK
+}
+
+func opName(op uint16) string {
+	switch op {
+	case consts.FlagPut:
+		return "put"
+	case consts.FlagDel:
+		return "del"
+	case consts.FlagBegin:
+		return "begin"
+	case consts.FlagCommit:
+		return "commit"
+	}
+	return fmt.Sprintf("op%d", op)
+}
+
+// layout 按各段记录引用的 value 块还原每段 [ValEnd, Size) 的布局，块之间的空隙记为 unreferenced。
+// 同一块可能先后被多条记录引用（覆盖写复用了空闲块），按记录顺序全部列出。
+func layout(segs []*Segment, files map[uint32][]byte) {
+	byID := make(map[uint32]*Segment, len(segs))
+	for _, s := range segs {
+		byID[s.ID] = s
+	}
+	type key struct {
+		seg uint32
+		off uint64
+	}
+	blocks := make(map[key]*Block)
+	add := func(seg uint32, off uint64, n uint32, kind string, ref Ref) {
+		if _, ok := byID[seg]; !ok || n == 0 {
+			return
+		}
+		k := key{seg, off}
+		b := blocks[k]
+		if b == nil {
+			b = &Block{Off: off, Len: uint64(segment.SizeClass(n)), Kind: kind}
+			blocks[k] = b
+		}
+		b.Refs = append(b.Refs, ref)
+	}
+	for _, s := range segs {
+		for _, r := range s.Records {
+			if r.Op != "put" || r.CRC == CRCMissing || r.CRC == CRCRange {
+				continue
+			}
+			ref := Ref{Seg: s.ID, Off: r.Off, Key: r.Key, Seq: r.Seq}
+			if !strings.Contains(r.Flags, "chunked") {
+				add(r.ValSeg, r.ValOff, r.ValLen, "value", ref)
+				continue
+			}
+			add(r.ValSeg, r.ValOff, r.ValLen, "chunks", ref)
+			val, _ := value(files, r.ValSeg, r.ValOff, uint64(r.ValLen))
+			chunks, _, _ := record.DecodeChunks(val)
+			for _, c := range chunks {
+				add(c.Seg, c.Off, c.Len, "chunk", ref)
+			}
+		}
+	}
+	for k, b := range blocks {
+		s := byID[k.seg]
+		s.Blocks = append(s.Blocks, *b)
+	}
+	for _, s := range segs {
+		sort.Slice(s.Blocks, func(i, j int) bool { return s.Blocks[i].Off < s.Blocks[j].Off })
+		// 分块可能落在本段记录压低的 valEnd 之下，布局从最低的块开始。
+		pos := s.ValEnd
+		if len(s.Blocks) > 0 {
+			pos = min(pos, s.Blocks[0].Off)
+		}
+		var out []Block
+		for _, b := range s.Blocks {
+			if b.Off > pos {
+				out = append(out, Block{Off: pos, Len: b.Off - pos, Kind: "unreferenced"})
+			}
+			out = append(out, b)
+			pos = max(pos, b.Off+b.Len)
+		}
+		if end := uint64(s.Size); pos < end && len(out) > 0 {
+			out = append(out, Block{Off: pos, Len: end - pos, Kind: "unreferenced"})
+		}
+		s.Blocks = out
+	}
+}
@@ -0,0 +1,89 @@
+package inspect
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"shm_master/internal/engine"
+	"shm_master/internal/fs"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "kv.data")
+	db, err := engine.Open(base, 64<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range []struct{ k, v string }{{"a", "one"}, {"b", "two"}, {"a", "three"}} {
+		if err := db.Set(kv.k, []byte(kv.v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Del("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set("big", bytes.Repeat([]byte("0123456789"), 10000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := Inspect(base, Options{Layout: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.Manifest || len(rep.Segments) < 2 {
+		t.Fatalf("manifest=%v segments=%d", rep.Manifest, len(rep.Segments))
+	}
+	seg0 := rep.Segments[0]
+	var ops []string
+	for _, r := range seg0.Records {
+		ops = append(ops, r.Op+":"+r.Key)
+		if r.Op == "put" && r.Live && r.CRC != CRCOK {
+			t.Errorf("%s: crc %s", r.Key, r.CRC)
+		}
+	}
+	if got := len(ops); got < 4 || ops[0] != "put:a" || ops[3] != "del:b" {
+		t.Fatalf("records %v", ops)
+	}
+	if seg0.Stop != nil || seg0.Super == nil || seg0.SuperErr != "" {
+		t.Errorf("seg 0: stop=%+v super=%+v err=%q", seg0.Stop, seg0.Super, seg0.SuperErr)
+	}
+	var chunks int
+	for _, s := range rep.Segments {
+		for _, b := range s.Blocks {
+			if b.Kind == "chunk" {
+				chunks++
+			}
+		}
+	}
+	if chunks < 2 {
+		t.Errorf("%d chunk blocks in layout", chunks)
+	}
+
+	// 破坏第二条记录的头部：解析在此停止，其后的记录计入丢弃字节。
+	path := fs.SegPath(base, 0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := seg0.Records[1].Off
+	data[off+10] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	id := uint32(0)
+	rep, err = Inspect(base, Options{Seg: &id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := rep.Segments[0]
+	if len(rep.Segments) != 1 || len(s.Records) != 1 || s.Stop == nil || s.Stop.Off != off {
+		t.Fatalf("after corruption: %d records, stop %+v", len(s.Records), s.Stop)
+	}
+	if s.Stop.Reason != "header checksum mismatch" || s.Stop.Discarded < seg0.LogEnd-off {
+		t.Errorf("stop %+v, log end was %d", s.Stop, seg0.LogEnd)
+	}
+}
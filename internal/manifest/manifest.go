@@ -0,0 +1,60 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"shm_master/internal/errs"
+	"shm_master/internal/fs"
+)
+
+// Version manifest 格式版本。
+const Version = 1
+
+// State 段状态。
+type State string
+
+const (
+	Active  State = "active"
+	Sealed  State = "sealed"
+	Retired State = "retired"
+)
+
+// SegInfo 单个段的登记信息。
+type SegInfo struct {
+	ID    uint32 `json:"id"`
+	State State  `json:"state"`
+	Size  int64  `json:"size"`
+}
+
+// Manifest 记录库标识与全部段（含已退役段），按 id 升序。
+type Manifest struct {
+	Version int       `json:"version"`
+	DBID    string    `json:"db_id"`
+	Segs    []SegInfo `json:"segments"`
+}
+
+// Load 读取 manifest；文件不存在时返回的错误满足 os.IsNotExist。
+func Load(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%w: manifest %s: %v", errs.ErrCorrupt, path, err)
+	}
+	if m.Version > Version {
+		return nil, fmt.Errorf("%w: manifest version %d: %s", errs.ErrMismatch, m.Version, path)
+	}
+	return &m, nil
+}
+
+// Save 原子替换 manifest，见 fs.WriteAtomic。
+func Save(path string, m *Manifest, perm os.FileMode) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fs.WriteAtomic(path, b, perm)
+}
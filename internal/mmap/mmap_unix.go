@@ -3,6 +3,8 @@
 package mmap
 
 import (
+	"os"
+
 	"golang.org/x/sys/unix"
 )
 
@@ -11,11 +13,27 @@ func Map(fd uintptr, size int) ([]byte, error) {
 	return unix.Mmap(int(fd), 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
 }
 
+// MapReadOnly 将文件 fd 的 [0, size) 以只读方式共享映射，写入映射区会触发 SIGSEGV。
+func MapReadOnly(fd uintptr, size int) ([]byte, error) {
+	return unix.Mmap(int(fd), 0, size, unix.PROT_READ, unix.MAP_SHARED)
+}
+
 // Sync 将映射区刷回磁盘。
 func Sync(data []byte) error {
 	return unix.Msync(data, unix.MS_SYNC)
 }
 
+// SyncRange 将映射区中 [off, off+n) 所在的页刷回磁盘。data 须为 Map 返回的完整映射。
+func SyncRange(data []byte, off, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	page := os.Getpagesize()
+	lo := off &^ (page - 1)
+	hi := min(off+n, len(data))
+	return unix.Msync(data[lo:hi], unix.MS_SYNC)
+}
+
 // Unmap 解除映射。
 func Unmap(data []byte) error {
 	return unix.Munmap(data)
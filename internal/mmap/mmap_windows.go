@@ -10,10 +10,18 @@ func Map(fd uintptr, size int) ([]byte, error) {
 	return nil, ErrNotSupported
 }
 
+func MapReadOnly(fd uintptr, size int) ([]byte, error) {
+	return nil, ErrNotSupported
+}
+
 func Sync(data []byte) error {
 	return ErrNotSupported
 }
 
+func SyncRange(data []byte, off, n int) error {
+	return ErrNotSupported
+}
+
 func Unmap(data []byte) error {
 	return nil
 }
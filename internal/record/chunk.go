@@ -0,0 +1,64 @@
+package record
+
+import "encoding/binary"
+
+// Chunk 分块 value 中的一块：所在段、偏移、长度与 CRC32C。
+type Chunk struct {
+	Seg uint32
+	Off uint64
+	Len uint32
+	CRC uint32
+}
+
+const (
+	chunkHeadSize  = 8 + 4         // total + count
+	chunkEntrySize = 4 + 8 + 4 + 4 // seg/off/len/crc
+)
+
+// ChunksLen 返回 n 块的分块清单编码长度。
+func ChunksLen(n int) int { return chunkHeadSize + n*chunkEntrySize }
+
+// EncodeChunks 编码分块清单：total(8) count(4)，随后每块 seg(4) off(8) len(4) crc(4)。
+func EncodeChunks(chunks []Chunk) []byte {
+	b := make([]byte, ChunksLen(len(chunks)))
+	var total uint64
+	for i, c := range chunks {
+		p := b[chunkHeadSize+i*chunkEntrySize:]
+		binary.LittleEndian.PutUint32(p[0:4], c.Seg)
+		binary.LittleEndian.PutUint64(p[4:12], c.Off)
+		binary.LittleEndian.PutUint32(p[12:16], c.Len)
+		binary.LittleEndian.PutUint32(p[16:20], c.CRC)
+		total += uint64(c.Len)
+	}
+	binary.LittleEndian.PutUint64(b[0:8], total)
+	binary.LittleEndian.PutUint32(b[8:12], uint32(len(chunks)))
+	return b
+}
+
+// DecodeChunks 解码分块清单并返回 value 总长；长度与块数不符时 ok 为 false。
+func DecodeChunks(b []byte) (chunks []Chunk, total uint64, ok bool) {
+	if len(b) < chunkHeadSize {
+		return nil, 0, false
+	}
+	total = binary.LittleEndian.Uint64(b[0:8])
+	n := int(binary.LittleEndian.Uint32(b[8:12]))
+	if len(b) != ChunksLen(n) {
+		return nil, 0, false
+	}
+	chunks = make([]Chunk, n)
+	var sum uint64
+	for i := range chunks {
+		p := b[chunkHeadSize+i*chunkEntrySize:]
+		chunks[i] = Chunk{
+			Seg: binary.LittleEndian.Uint32(p[0:4]),
+			Off: binary.LittleEndian.Uint64(p[4:12]),
+			Len: binary.LittleEndian.Uint32(p[12:16]),
+			CRC: binary.LittleEndian.Uint32(p[16:20]),
+		}
+		sum += uint64(chunks[i].Len)
+	}
+	if sum != total {
+		return nil, 0, false
+	}
+	return chunks, total, true
+}
@@ -3,9 +3,18 @@ package record
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"shm_master/consts"
 )
 
-// Header 记录头（magic/version/type/len/crc）
+// Header 记录头，v1 与 v2 解码到同一结构。
+//
+// v2 布局（HeaderSize 字节，key 紧随其后）：
+//
+//	magic 0..4 ver 4..6 flags 6..8 keyLen 8..10 rsv 10..12 valLen 12..16 valOff 16..24
+//	valSeg 24..28 valCRC 28..32 seq 32..40 ext 40..48 crc 48..52
+//
+// v1 布局（HeaderSizeV1 字节）：magic/ver/flags/keyLen/rsv/valLen/valOff 同上，crc 24..28。
+// v1 已冻结：只有 Put/Del，value 与记录同段、不带校验和；新字段只加在 v2，旧数据由 Upgrade 转换。
 type Header struct {
 	Magic  uint32
 	Ver    uint16
@@ -14,10 +23,66 @@ type Header struct {
 	_      uint16
 	ValLen uint32
 	ValOff uint64
-	CRC32  uint32
+	// ValSeg 为 value 所在段 id；v1 记录由 Parse 填为记录所在段。
+	ValSeg uint32
+	// ValCRC 为 value 的 CRC32C；v1 记录没有。
+	ValCRC uint32
+	Seq    uint64
+	// Ext 为按 Flags 解释的扩展值，未使用时为 0。
+	Ext   uint64
+	CRC32 uint32
 }
 
-// DecodeHeader 从 data 解码一条记录头。
+// v2 字段偏移。
+const (
+	offValSeg = 24
+	offValCRC = 28
+	offSeq    = 32
+	offExt    = 40
+	offCRC    = 48
+)
+
+// Op 返回记录的操作类型（FlagPut/FlagDel/FlagBegin/FlagCommit）。
+func (h Header) Op() uint16 { return h.Flags & consts.FlagOpMask }
+
+// IsMarker 报告是否为不带 key 的批量边界记录。
+func (h Header) IsMarker() bool {
+	op := h.Op()
+	return op == consts.FlagBegin || op == consts.FlagCommit
+}
+
+// HasValCRC 报告记录是否带有 value 校验和。
+func (h Header) HasValCRC() bool {
+	return h.Op() == consts.FlagPut && h.Ver >= consts.Version2
+}
+
+// ExpireAt 返回 Put 记录的过期时间（Unix 纳秒），0 表示永不过期。
+func (h Header) ExpireAt() int64 {
+	if h.Op() != consts.FlagPut || h.Flags&consts.FlagTTL == 0 {
+		return 0
+	}
+	return int64(h.Ext)
+}
+
+// Chunked 报告 Put 记录的 value 是否为分块清单。
+func (h Header) Chunked() bool {
+	return h.Op() == consts.FlagPut && h.Flags&consts.FlagChunked != 0
+}
+
+// HeaderLen 返回该版本记录头的长度。
+func (h Header) HeaderLen() uint64 {
+	if h.Ver == consts.Version1 {
+		return consts.HeaderSizeV1
+	}
+	return consts.HeaderSize
+}
+
+// RecLen 返回整条记录在 log 区的长度。
+func (h Header) RecLen() uint64 {
+	return h.HeaderLen() + uint64(h.KeyLen)
+}
+
+// DecodeHeader 从 data 解码一条 v1 记录头。
 func DecodeHeader(data []byte) Header {
 	return Header{
 		Magic:  binary.LittleEndian.Uint32(data[0:4]),
@@ -30,7 +95,7 @@ func DecodeHeader(data []byte) Header {
 	}
 }
 
-// EncodeHeader 将 h 编码到 b（至少 HeaderSize 字节）。
+// EncodeHeader 将 h 按 v1 布局编码到 b（至少 HeaderSizeV1 字节），仅用于兼容与测试。
 func EncodeHeader(b []byte, h Header) {
 	binary.LittleEndian.PutUint32(b[0:4], h.Magic)
 	binary.LittleEndian.PutUint16(b[4:6], h.Ver)
@@ -42,7 +107,60 @@ func EncodeHeader(b []byte, h Header) {
 	binary.LittleEndian.PutUint32(b[24:28], h.CRC32)
 }
 
-// CalcCRC 计算记录 CRC（与 DecodeHeader 约定一致）。
+// DecodeHeaderV2 从 data 解码一条 v2 记录头。
+func DecodeHeaderV2(data []byte) Header {
+	h := DecodeHeader(data)
+	h.ValSeg = binary.LittleEndian.Uint32(data[offValSeg : offValSeg+4])
+	h.ValCRC = binary.LittleEndian.Uint32(data[offValCRC : offValCRC+4])
+	h.Seq = binary.LittleEndian.Uint64(data[offSeq : offSeq+8])
+	h.Ext = binary.LittleEndian.Uint64(data[offExt : offExt+8])
+	h.CRC32 = binary.LittleEndian.Uint32(data[offCRC : offCRC+4])
+	return h
+}
+
+// EncodeHeaderV2 将 h 按 v2 布局编码到 b（至少 HeaderSize 字节），CRC 字段原样写入。
+func EncodeHeaderV2(b []byte, h Header) {
+	binary.LittleEndian.PutUint32(b[0:4], h.Magic)
+	binary.LittleEndian.PutUint16(b[4:6], h.Ver)
+	binary.LittleEndian.PutUint16(b[6:8], h.Flags)
+	binary.LittleEndian.PutUint16(b[8:10], h.KeyLen)
+	binary.LittleEndian.PutUint16(b[10:12], 0)
+	binary.LittleEndian.PutUint32(b[12:16], h.ValLen)
+	binary.LittleEndian.PutUint64(b[16:24], h.ValOff)
+	binary.LittleEndian.PutUint32(b[offValSeg:offValSeg+4], h.ValSeg)
+	binary.LittleEndian.PutUint32(b[offValCRC:offValCRC+4], h.ValCRC)
+	binary.LittleEndian.PutUint64(b[offSeq:offSeq+8], h.Seq)
+	binary.LittleEndian.PutUint64(b[offExt:offExt+8], h.Ext)
+	binary.LittleEndian.PutUint32(b[offCRC:offCRC+4], h.CRC32)
+}
+
+// Write 把 v2 记录（头 + key）写入 b 并填好 CRC，返回写入的字节数。
+func Write(b []byte, h Header, key string) uint64 {
+	h.Magic = consts.Magic
+	h.Ver = consts.Version2
+	h.KeyLen = uint16(len(key))
+	EncodeHeaderV2(b, h)
+	keyEnd := consts.HeaderSize + len(key)
+	copy(b[consts.HeaderSize:keyEnd], key)
+	crc := CalcCRCV2(b[:consts.HeaderSize], b[consts.HeaderSize:keyEnd])
+	binary.LittleEndian.PutUint32(b[offCRC:offCRC+4], crc)
+	return uint64(keyEnd)
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ValueCRC 计算 value 的 CRC32C（硬件加速）。
+func ValueCRC(val []byte) uint32 {
+	return crc32.Checksum(val, castagnoli)
+}
+
+// CalcCRCV2 计算 v2 记录 CRC：CRC32C 覆盖 crc 字段之前的整个头部与 key。
+func CalcCRCV2(hdr []byte, key []byte) uint32 {
+	c := crc32.Update(0, castagnoli, hdr[:offCRC])
+	return crc32.Update(c, castagnoli, key)
+}
+
+// CalcCRC 计算 v1 记录 CRC（与 DecodeHeader 约定一致）。
 func CalcCRC(flags uint16, keyLen uint16, valLen uint32, valOff uint64, key []byte) uint32 {
 	var tmp [2 + 2 + 4 + 8]byte
 	binary.LittleEndian.PutUint16(tmp[0:2], flags)
@@ -0,0 +1,98 @@
+package record
+
+import (
+	"encoding/binary"
+	"fmt"
+	"shm_master/consts"
+)
+
+// Parse 解析 data[off:limit) 处的一条记录（自动识别 v1/v2）并校验头部 CRC。
+// segID 为记录所在段，即 v1 记录的 ValSeg。ok 为 false 表示此处没有合法记录。
+// value 本身的校验与范围检查由调用方负责。
+func Parse(data []byte, off, limit uint64, segID uint32) (h Header, key []byte, ok bool) {
+	if limit > uint64(len(data)) {
+		limit = uint64(len(data))
+	}
+	if off+consts.HeaderSizeV1 > limit {
+		return Header{}, nil, false
+	}
+	b := data[off:limit]
+	switch binary.LittleEndian.Uint16(b[4:6]) {
+	case consts.Version2:
+		if uint64(len(b)) < consts.HeaderSize {
+			return Header{}, nil, false
+		}
+		h = DecodeHeaderV2(b)
+	case consts.Version1:
+		h = DecodeHeader(b)
+	default:
+		return Header{}, nil, false
+	}
+	if h.Magic != consts.Magic || (h.KeyLen == 0) != (h.Ver == consts.Version2 && h.IsMarker()) {
+		return Header{}, nil, false
+	}
+	recLen := h.RecLen()
+	if recLen > uint64(len(b)) {
+		return Header{}, nil, false
+	}
+	keyStart := h.HeaderLen()
+	keyEnd := keyStart + uint64(h.KeyLen)
+	key = b[keyStart:keyEnd]
+	if h.Ver == consts.Version2 {
+		if CalcCRCV2(b[:consts.HeaderSize], key) != h.CRC32 {
+			return Header{}, nil, false
+		}
+		return h, key, true
+	}
+	// v1 只有同段的 Put/Del。
+	if (h.Flags != consts.FlagPut && h.Flags != consts.FlagDel) ||
+		CalcCRC(h.Flags, h.KeyLen, h.ValLen, h.ValOff, key) != h.CRC32 {
+		return Header{}, nil, false
+	}
+	h.ValSeg = segID
+	return h, key, true
+}
+
+// Diagnose 说明 Parse 为什么不接受 data[off:limit) 处的记录；此处是全零的空记录头（log 正常结束）时返回空串。
+func Diagnose(data []byte, off, limit uint64) string {
+	limit = min(limit, uint64(len(data)))
+	if off >= limit {
+		return ""
+	}
+	b := data[off:limit]
+	zero := true
+	for _, c := range b[:min(len(b), consts.HeaderSize)] {
+		if c != 0 {
+			zero = false
+			break
+		}
+	}
+	if zero {
+		return ""
+	}
+	if len(b) < consts.HeaderSizeV1 {
+		return "truncated header"
+	}
+	h := DecodeHeader(b)
+	switch {
+	case h.Magic != consts.Magic:
+		return fmt.Sprintf("bad magic %#08x", h.Magic)
+	case h.Ver != consts.Version1 && h.Ver != consts.Version2:
+		return fmt.Sprintf("unknown version %d", h.Ver)
+	case h.Ver == consts.Version2 && len(b) < consts.HeaderSize:
+		return "truncated header"
+	}
+	if h.Ver == consts.Version2 {
+		h = DecodeHeaderV2(b)
+	}
+	if h.RecLen() > uint64(len(b)) {
+		return "record overruns value region"
+	}
+	if (h.KeyLen == 0) != (h.Ver == consts.Version2 && h.IsMarker()) {
+		return "bad key length"
+	}
+	if h.Ver == consts.Version1 && h.Flags != consts.FlagPut && h.Flags != consts.FlagDel {
+		return fmt.Sprintf("unsupported v1 flags %#04x", h.Flags)
+	}
+	return "header checksum mismatch"
+}
@@ -0,0 +1,495 @@
+// Package salvage 从损坏的库中抢救记录：逐段扫描 log，遇到非法记录时按 consts.Magic 重新同步，
+// 把所有能校验通过的记录按序列号合并后写入一个新库，并报告丢失了什么。源文件只以只读方式映射，不做任何修改。
+package salvage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"shm_master/consts"
+	"shm_master/internal/engine"
+	"shm_master/internal/fs"
+	"shm_master/internal/manifest"
+	"shm_master/internal/mmap"
+	"shm_master/internal/record"
+	"shm_master/internal/segment"
+	"sort"
+	"time"
+)
+
+// 记录被丢弃的原因。
+const (
+	LostValueCRC     = "value checksum mismatch"
+	LostValueRange   = "value out of range"
+	LostValueSegment = "value segment missing"
+	LostChunkList    = "bad chunk list"
+	LostChunkCRC     = "chunk checksum mismatch"
+	LostBatch        = "uncommitted batch"
+)
+
+// Report 一次抢救的结果。
+type Report struct {
+	Source   string          `json:"source"`
+	Dest     string          `json:"dest"`
+	Segments []SegmentReport `json:"segments"`
+	// Keys 为写入新库的 key 数；Deleted 为最新记录是墓碑的 key 数，Expired 为已过期而未写入的 key 数。
+	Keys    int    `json:"keys"`
+	Deleted int    `json:"deleted"`
+	Expired int    `json:"expired"`
+	Lost    []Lost `json:"lost"`
+	// Skipped 为未扫描的段文件及原因，例如属于另一个库。
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// SegmentReport 单个段的扫描结果。
+type SegmentReport struct {
+	ID      uint32 `json:"id"`
+	Records int    `json:"records"` // 头部校验通过的记录数
+	// Corrupt 为 log 区中跳过的非零字节范围，其中的记录已无法识别。
+	Corrupt []Region `json:"corrupt,omitempty"`
+}
+
+// Region 段内一段字节。
+type Region struct {
+	Off uint64 `json:"off"`
+	Len uint64 `json:"len"`
+}
+
+// Lost 一条头部完好、但未能抢救的记录。Superseded 为 true 时该 key 有更新的记录被抢救，丢失无影响。
+type Lost struct {
+	Seg        uint32 `json:"seg"`
+	Off        uint64 `json:"off"`
+	Key        string `json:"key"`
+	Seq        uint64 `json:"seq"`
+	Reason     string `json:"reason"`
+	Superseded bool   `json:"superseded"`
+}
+
+// found 扫描到的一条 Put/Del 记录。
+type found struct {
+	seg uint32
+	off uint64
+	h   record.Header
+	key string
+	val []byte // 已校验的 value，分块 value 已拼接
+}
+
+// newer 报告 a 是否比 b 新：先比序列号，v1 记录没有序列号时按所在段与偏移。
+func (a *found) newer(b *found) bool {
+	if a.h.Seq != b.h.Seq {
+		return a.h.Seq > b.h.Seq
+	}
+	if a.seg != b.seg {
+		return a.seg > b.seg
+	}
+	return a.off > b.off
+}
+
+// Salvage 扫描 src 的段文件，把抢救出的最新数据写入新库 dst；dst 不能已有段文件，所在目录不存在时创建。
+// segSize 为 dst 的段大小。
+func Salvage(src, dst string, segSize int64) (*Report, error) {
+	if filepath.Clean(src) == filepath.Clean(dst) {
+		return nil, errors.New("salvage: destination must differ from source")
+	}
+	if ids, err := fs.ListSegIDs(dst); err != nil {
+		return nil, err
+	} else if len(ids) > 0 {
+		return nil, fmt.Errorf("salvage: %s already has segment files", dst)
+	}
+	s := &scanner{rep: &Report{Source: src, Dest: dst}, files: make(map[uint32][]byte)}
+	defer s.close()
+	ids, err := s.open(src)
+	if err != nil {
+		return nil, err
+	}
+	scans := make([]*segScan, len(ids))
+	for i, id := range ids {
+		scans[i] = s.collect(id)
+	}
+	floors := s.floors(scans)
+	for _, sc := range scans {
+		s.process(sc, floors[sc.id])
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return nil, err
+	}
+	return s.rep, s.write(dst, segSize)
+}
+
+type scanner struct {
+	rep    *Report
+	files  map[uint32][]byte
+	latest map[string]*found
+	lost   []*found
+	reason map[*found]string
+}
+
+func (s *scanner) close() {
+	for _, data := range s.files {
+		_ = mmap.Unmap(data)
+	}
+}
+
+// open 映射要扫描的段：manifest 可读时取其中未退役的段，否则取目录中全部段文件；
+// 超级块标识与 manifest 不符的文件跳过。
+func (s *scanner) open(base string) ([]uint32, error) {
+	ids, err := fs.ListSegIDs(base)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("salvage: no segment files for %s", base)
+	}
+	var dbID segment.DBID
+	listed := map[uint32]bool(nil)
+	if mf, err := manifest.Load(fs.ManifestPath(base)); err == nil {
+		listed = make(map[uint32]bool)
+		for _, si := range mf.Segs {
+			listed[si.ID] = si.State != manifest.Retired
+		}
+		dbID, _ = segment.ParseDBID(mf.DBID)
+	}
+	var out []uint32
+	for _, id := range ids {
+		path := fs.SegPath(base, id)
+		if listed != nil && !listed[id] {
+			s.rep.Skipped = append(s.rep.Skipped, path+": not listed in manifest")
+			continue
+		}
+		data, err := mapFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			s.rep.Skipped = append(s.rep.Skipped, path+": empty")
+			continue
+		}
+		if sb, ok, corrupt := segment.DecodeSuper(data); ok && !corrupt && !dbID.IsZero() && sb.DBID != dbID {
+			_ = mmap.Unmap(data)
+			s.rep.Skipped = append(s.rep.Skipped, path+": belongs to another database")
+			continue
+		}
+		s.files[id] = data
+		out = append(out, id)
+	}
+	return out, nil
+}
+
+// mapFile 只读映射 path，空文件返回 nil。
+func mapFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() == 0 {
+		return nil, nil
+	}
+	return mmap.MapReadOnly(f.Fd(), int(st.Size()))
+}
+
+// hit 扫描到的一条头部校验通过的记录。
+type hit struct {
+	off uint64
+	h   record.Header
+	key string
+}
+
+// segScan 一个段文件的扫描结果。
+type segScan struct {
+	id    uint32
+	start uint64
+	hits  []hit
+	gaps  []Region // 无法解析、被跳过的范围
+}
+
+// collect 扫描段 id 的整个文件：解析失败时从下一个 consts.Magic 处重试。
+func (s *scanner) collect(id uint32) *segScan {
+	data := s.files[id]
+	sc := &segScan{id: id}
+	if _, ok, _ := segment.DecodeSuper(data); ok {
+		sc.start = consts.SuperSize
+	}
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], consts.Magic)
+	off := sc.start
+	for off < uint64(len(data)) {
+		h, key, ok := record.Parse(data, off, uint64(len(data)), id)
+		if ok {
+			sc.hits = append(sc.hits, hit{off, h, string(key)})
+			off += h.RecLen()
+			continue
+		}
+		next := uint64(len(data))
+		if i := bytes.Index(data[off+1:], magic[:]); i >= 0 {
+			next = off + 1 + uint64(i)
+		}
+		sc.gaps = append(sc.gaps, Region{Off: off, Len: next - off})
+		off = next
+	}
+	return sc
+}
+
+// floors 根据所有记录引用的 value 与分块估计各段 value 区的下界：log 只会在它之下。
+func (s *scanner) floors(scans []*segScan) map[uint32]uint64 {
+	fl := make(map[uint32]uint64, len(scans))
+	lower := func(id uint32, off uint64) {
+		if cur, ok := fl[id]; !ok || off < cur {
+			fl[id] = off
+		}
+	}
+	for _, sc := range scans {
+		fl[sc.id] = uint64(len(s.files[sc.id]))
+	}
+	for _, sc := range scans {
+		for _, ht := range sc.hits {
+			h := ht.h
+			if h.Op() != consts.FlagPut || (h.ValSeg == sc.id && h.ValOff <= ht.off) {
+				continue
+			}
+			lower(h.ValSeg, h.ValOff)
+			if !h.Chunked() {
+				continue
+			}
+			list, reason := s.value(h.ValSeg, h.ValOff, uint64(h.ValLen))
+			if reason != "" || record.ValueCRC(list) != h.ValCRC {
+				continue
+			}
+			chunks, _, _ := record.DecodeChunks(list)
+			for _, c := range chunks {
+				lower(c.Seg, c.Off)
+			}
+		}
+	}
+	return fl
+}
+
+// process 按批次语义合并段 sc 中位于 value 区下界 floor 之下的记录；
+// 之上的命中都是 value 中的巧合数据，直接忽略。
+func (s *scanner) process(sc *segScan, floor uint64) {
+	data := s.files[sc.id]
+	sr := SegmentReport{ID: sc.id}
+	for _, g := range sc.gaps {
+		// 只报告含有非零字节的范围；log 末尾与 value 区之间的全零空隙是正常的。
+		end := min(g.Off+g.Len, floor)
+		if g.Off >= end {
+			continue
+		}
+		if b := bytes.TrimRight(data[g.Off:end], "\x00"); len(b) > 0 {
+			sr.Corrupt = append(sr.Corrupt, Region{Off: g.Off, Len: uint64(len(b))})
+		}
+	}
+
+	var hits []hit
+	for _, ht := range sc.hits {
+		if ht.off >= floor {
+			break
+		}
+		hits = append(hits, ht)
+	}
+	sr.Records = len(hits)
+	torn := tornBatches(hits)
+	for _, ht := range hits {
+		if ht.h.IsMarker() {
+			continue
+		}
+		f := s.verify(sc.id, ht.off, ht.h, ht.key)
+		if torn.has(ht.h.Seq) {
+			s.drop(f, LostBatch)
+			continue
+		}
+		s.apply(f)
+	}
+	s.rep.Segments = append(s.rep.Segments, sr)
+}
+
+// seqRange 闭区间 [lo, hi] 内的序列号。
+type seqRange struct{ lo, hi uint64 }
+
+type seqRanges []seqRange
+
+func (rs seqRanges) has(seq uint64) bool {
+	for _, r := range rs {
+		if seq >= r.lo && seq <= r.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// tornBatches 找出 hits 中不完整的批次，返回其操作的序列号范围。完整的批次是 Begin、
+// 紧随其后的 Ext 条序列号连续的操作与对应的 Commit，三者之间没有被跳过的字节；
+// Begin 或 Commit 落在损坏区域时，仅凭另一端也能确定批内操作的序列号范围。
+func tornBatches(hits []hit) seqRanges {
+	var torn seqRanges
+	matched := make(map[int]bool) // 已与 Begin 配对的 Commit
+	for i, ht := range hits {
+		if ht.h.Op() != consts.FlagBegin {
+			continue
+		}
+		begin, n := ht.h.Seq, ht.h.Ext
+		ok, end := true, ht.off+ht.h.RecLen()
+		j := i + 1
+		for ; j < len(hits) && uint64(j-i-1) < n; j++ {
+			h := hits[j].h
+			if hits[j].off != end || h.IsMarker() || h.Seq != begin+uint64(j-i) {
+				ok = false
+				break
+			}
+			end += h.RecLen()
+		}
+		if ok && j < len(hits) && hits[j].off == end && hits[j].h.Op() == consts.FlagCommit && hits[j].h.Ext == begin {
+			matched[j] = true
+			continue
+		}
+		torn = append(torn, seqRange{begin + 1, begin + n})
+	}
+	for i, ht := range hits {
+		if ht.h.Op() == consts.FlagCommit && !matched[i] && ht.h.Seq > ht.h.Ext+1 {
+			torn = append(torn, seqRange{ht.h.Ext + 1, ht.h.Seq - 1})
+		}
+	}
+	return torn
+}
+
+// verify 取出并校验 Put 记录的 value；校验失败时在 f 上记下原因。
+func (s *scanner) verify(seg uint32, off uint64, h record.Header, key string) *found {
+	f := &found{seg: seg, off: off, h: h, key: key}
+	if h.Op() != consts.FlagPut {
+		return f
+	}
+	val, reason := s.value(h.ValSeg, h.ValOff, uint64(h.ValLen))
+	if reason == "" && h.HasValCRC() && record.ValueCRC(val) != h.ValCRC {
+		reason = LostValueCRC
+	}
+	if reason == "" && h.Chunked() {
+		val, reason = s.assemble(val)
+	}
+	if reason != "" {
+		s.markLost(f, reason)
+		return f
+	}
+	f.val = val
+	return f
+}
+
+// value 返回段 id 中 [off, off+n) 的内容，取不到时返回原因。
+func (s *scanner) value(id uint32, off, n uint64) ([]byte, string) {
+	data, ok := s.files[id]
+	if !ok {
+		return nil, LostValueSegment
+	}
+	if off+n < off || off+n > uint64(len(data)) {
+		return nil, LostValueRange
+	}
+	return data[off : off+n], ""
+}
+
+// assemble 按分块清单拼接并校验分块 value。
+func (s *scanner) assemble(list []byte) ([]byte, string) {
+	chunks, total, ok := record.DecodeChunks(list)
+	if !ok {
+		return nil, LostChunkList
+	}
+	out := make([]byte, 0, total)
+	for _, c := range chunks {
+		b, reason := s.value(c.Seg, c.Off, uint64(c.Len))
+		if reason != "" {
+			return nil, reason
+		}
+		if record.ValueCRC(b) != c.CRC {
+			return nil, LostChunkCRC
+		}
+		out = append(out, b...)
+	}
+	return out, ""
+}
+
+func (s *scanner) markLost(f *found, reason string) {
+	if s.reason == nil {
+		s.reason = make(map[*found]string)
+	}
+	s.reason[f] = reason
+}
+
+// drop 把 Put/Del 记录 f 记为丢失。
+func (s *scanner) drop(f *found, reason string) {
+	if _, ok := s.reason[f]; !ok {
+		s.markLost(f, reason)
+	}
+	s.lost = append(s.lost, f)
+}
+
+// apply 让 f 参与合并：校验失败的记为丢失，其余按新旧保留每个 key 的最新记录。
+func (s *scanner) apply(f *found) {
+	if _, bad := s.reason[f]; bad {
+		s.lost = append(s.lost, f)
+		return
+	}
+	if s.latest == nil {
+		s.latest = make(map[string]*found)
+	}
+	if cur, ok := s.latest[f.key]; !ok || f.newer(cur) {
+		s.latest[f.key] = f
+	}
+}
+
+// write 把每个 key 的最新记录写入新库 dst，并汇总丢失的记录。
+func (s *scanner) write(dst string, segSize int64) error {
+	for _, f := range s.lost {
+		cur, ok := s.latest[f.key]
+		s.rep.Lost = append(s.rep.Lost, Lost{
+			Seg: f.seg, Off: f.off, Key: f.key, Seq: f.h.Seq,
+			Reason: s.reason[f], Superseded: ok && cur.newer(f),
+		})
+	}
+	sort.Slice(s.rep.Lost, func(i, j int) bool {
+		a, b := s.rep.Lost[i], s.rep.Lost[j]
+		return a.Seg < b.Seg || (a.Seg == b.Seg && a.Off < b.Off)
+	})
+
+	db, err := engine.OpenWithOptions(dst, engine.Options{SegSize: segSize})
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(s.latest))
+	for k := range s.latest {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	now := time.Now()
+	for _, k := range keys {
+		f := s.latest[k]
+		if f.h.Op() == consts.FlagDel {
+			s.rep.Deleted++
+			continue
+		}
+		if exp := f.h.ExpireAt(); exp != 0 {
+			ttl := time.Unix(0, exp).Sub(now)
+			if ttl <= 0 {
+				s.rep.Expired++
+				continue
+			}
+			err = db.SetWithTTL(k, f.val, ttl)
+		} else {
+			err = db.Set(k, f.val)
+		}
+		if err != nil {
+			_ = db.Close()
+			return fmt.Errorf("salvage: write %q: %w", k, err)
+		}
+		s.rep.Keys++
+	}
+	if err := db.Sync(); err != nil {
+		_ = db.Close()
+		return err
+	}
+	return db.Close()
+}
@@ -0,0 +1,127 @@
+package salvage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"shm_master/internal/engine"
+	"shm_master/internal/fs"
+	"testing"
+)
+
+const testSegSize = 64 << 10
+
+func fileSum(t *testing.T, path string) [32]byte {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha256.Sum256(b)
+}
+
+func TestSalvagePastCorruption(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "kv.data")
+	dst := filepath.Join(dir, "dst", "kv.data")
+	if err := os.Mkdir(filepath.Dir(src), 0755); err != nil {
+		t.Fatal(err)
+	}
+	db, err := engine.Open(src, testSegSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[string][]byte)
+	for i := 0; i < 40; i++ {
+		k := fmt.Sprintf("k%02d", i)
+		want[k] = bytes.Repeat([]byte{byte('a' + i%26)}, 50+i)
+		if err := db.Set(k, want[k]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Apply([]engine.BatchOp{{Key: "b1", Value: []byte("one")}, {Key: "b2", Value: []byte("two")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Del("k00"); err != nil {
+		t.Fatal(err)
+	}
+	delete(want, "k00")
+	big := bytes.Repeat([]byte("0123456789"), testSegSize/5)
+	if err := db.Set("big", big); err != nil {
+		t.Fatal(err)
+	}
+	want["big"] = big
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 破坏 k10 的记录头与批次中 b1 的记录头：Recover 会在 k10 处停止，抢救则越过它们继续。
+	path := fs.SegPath(src, 0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"k10", "b1"} {
+		i := bytes.Index(data, []byte(key))
+		if i < 0 {
+			t.Fatalf("%s not found", key)
+		}
+		data[i-20] ^= 0xff // 头部中的 seq
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	delete(want, "k10")
+	sums := make(map[string][32]byte)
+	ids, _ := fs.ListSegIDs(src)
+	for _, id := range ids {
+		sums[fs.SegPath(src, id)] = fileSum(t, fs.SegPath(src, id))
+	}
+
+	rep, err := Salvage(src, dst, testSegSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, sum := range sums {
+		if fileSum(t, p) != sum {
+			t.Errorf("%s was modified", p)
+		}
+	}
+	if rep.Keys != len(want) || rep.Deleted != 1 {
+		t.Errorf("keys=%d deleted=%d, want %d and 1", rep.Keys, rep.Deleted, len(want))
+	}
+	if n := len(rep.Segments[0].Corrupt); n != 2 {
+		t.Errorf("%d corrupt regions in segment 0: %+v", n, rep.Segments[0].Corrupt)
+	}
+	var lostB2 bool
+	for _, l := range rep.Lost {
+		if l.Key == "b2" && l.Reason == LostBatch && !l.Superseded {
+			lostB2 = true
+		}
+	}
+	if !lostB2 {
+		t.Errorf("b2 should be lost with its torn batch: %+v", rep.Lost)
+	}
+
+	out, err := engine.OpenReadOnly(dst, testSegSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	for k, v := range want {
+		got, ok, err := out.Get(k)
+		if err != nil || !ok || !bytes.Equal(got, v) {
+			t.Errorf("%s: ok=%v err=%v len=%d", k, ok, err, len(got))
+		}
+	}
+	for _, k := range []string{"k00", "k10", "b1", "b2"} {
+		if _, ok, _ := out.Get(k); ok {
+			t.Errorf("%s should not be salvaged", k)
+		}
+	}
+	if _, err := Salvage(src, dst, testSegSize); err == nil {
+		t.Error("salvaging into a non-empty destination should fail")
+	}
+}
@@ -1,84 +1,457 @@
 package segment
 
 import (
+	"fmt"
 	"os"
+	"shm_master/internal/errs"
 	"shm_master/internal/fs"
+	"shm_master/internal/manifest"
+	"strings"
 )
 
-// Manager 管理多段：按 base 扫描已有段、追加新段。
+// Manager 管理多段：按 manifest 打开已有段、追加新段、退役旧段。
+// segs 以段 id 为下标，已退役或缺失的段位置为 nil。
 type Manager struct {
-	base    string
-	segSize int64
-	segs    []*Segment
+	base       string
+	segSize    int64
+	segs       []*Segment
+	retired    []*Segment
+	retiredIDs []uint32
+	orphans    []string
+	dbID       DBID
+	readOnly   bool
+	perm       os.FileMode
+	maxSegs    int // 存活段数上限，0 表示不限
 }
 
+// DefaultFileMode 新建段文件与 manifest 的默认权限。
+const DefaultFileMode os.FileMode = 0644
+
 // NewManager 创建 manager，不打开文件。
 func NewManager(base string, segSize int64) *Manager {
-	return &Manager{base: base, segSize: segSize, segs: make([]*Segment, 0, 4)}
+	return &Manager{base: base, segSize: segSize, segs: make([]*Segment, 0, 4), perm: DefaultFileMode}
+}
+
+// NewReadOnlyManager 创建只读 manager：只读映射已有段，不写 manifest、不创建或删除任何文件。
+func NewReadOnlyManager(base string, segSize int64) *Manager {
+	m := NewManager(base, segSize)
+	m.readOnly = true
+	return m
 }
 
-// OpenBase 扫描 base.000, base.001, ... 打开已存在的 segment。
+// SetFileMode 设置新建段文件与 manifest 的权限，需在 OpenBase 之前调用。
+func (m *Manager) SetFileMode(perm os.FileMode) { m.perm = perm }
+
+// FileMode 返回新建文件的权限。
+func (m *Manager) FileMode() os.FileMode { return m.perm }
+
+// SetMaxSegments 限制存活段数，n 为 0 时不限。达到上限后 ApnSeg 返回 ErrNoSpace；
+// 正在压缩的段不计入，以便压缩总能搬出数据并腾出段。
+func (m *Manager) SetMaxSegments(n int) { m.maxSegs = n }
+
+// MaxSegments 返回存活段数上限，0 表示不限。
+func (m *Manager) MaxSegments() int { return m.maxSegs }
+
+// ReadOnly 报告 manager 是否为只读。
+func (m *Manager) ReadOnly() bool { return m.readOnly }
+
+// DBID 返回库标识；尚未创建任何带超级块的段时为零值。
+func (m *Manager) DBID() DBID { return m.dbID }
+
+// Orphans 返回目录中存在、但 manifest 未登记为存活段的文件，Open 时不会加载它们。
+func (m *Manager) Orphans() []string { return m.orphans }
+
+// OpenBase 打开已存在的段。有 manifest 时以其为准并与目录比对：
+// 登记为存活但文件缺失时报错，目录中多出的文件记为孤儿；
+// 没有 manifest 时（新库或旧版本库）扫描目录，随后写出 manifest。
+// 先读 manifest 再列目录：写者总是先创建段文件再登记，这样并发追加的新段最多被当作孤儿。
 func (m *Manager) OpenBase() error {
-	for id := uint32(0); ; id++ {
-		p := fs.SegPath(m.base, id)
-		if _, err := os.Stat(p); err != nil {
-			if os.IsNotExist(err) {
-				break
-			}
+	mf, err := manifest.Load(fs.ManifestPath(m.base))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	onDisk, lerr := fs.ListSegIDs(m.base)
+	if lerr != nil {
+		return lerr
+	}
+	if err != nil {
+		return m.openScanned(onDisk)
+	}
+	return m.openManifest(mf, onDisk)
+}
+
+// openScanned 按目录扫描结果打开段，id 可以不连续。
+func (m *Manager) openScanned(ids []uint32) error {
+	for _, id := range ids {
+		if err := m.openOne(id, m.segSize); err != nil {
 			return err
 		}
-		seg, err := OpenSegment(p, id, m.segSize, false)
+	}
+	if len(ids) == 0 || m.readOnly {
+		return nil
+	}
+	return m.saveManifest()
+}
+
+// openManifest 按 manifest 打开段并与目录比对。
+func (m *Manager) openManifest(mf *manifest.Manifest, onDisk []uint32) error {
+	if mf.DBID != "" {
+		id, err := ParseDBID(mf.DBID)
 		if err != nil {
-			return err
+			return fmt.Errorf("%w: manifest db id %q", errs.ErrCorrupt, mf.DBID)
+		}
+		m.dbID = id
+	}
+	present := make(map[uint32]bool, len(onDisk))
+	for _, id := range onDisk {
+		present[id] = true
+	}
+	listed := make(map[uint32]bool, len(mf.Segs))
+	var missing []string
+	for _, si := range mf.Segs {
+		listed[si.ID] = true
+		switch si.State {
+		case manifest.Retired:
+			m.retiredIDs = append(m.retiredIDs, si.ID)
+			if present[si.ID] && !m.readOnly {
+				// 退役时删除文件之前崩溃，这里补删。
+				if err := os.Remove(fs.SegPath(m.base, si.ID)); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+			m.grow(si.ID)
+		case manifest.Active, manifest.Sealed:
+			if !present[si.ID] {
+				// 只读打开时写者可能刚退役并删除了该段，以写者为准跳过。
+				if !m.readOnly {
+					missing = append(missing, fs.SegPath(m.base, si.ID))
+				}
+				m.grow(si.ID)
+				continue
+			}
+			if err := m.openOne(si.ID, si.Size); err != nil {
+				if m.readOnly && os.IsNotExist(err) {
+					m.grow(si.ID)
+					continue
+				}
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: manifest segment %d has state %q", errs.ErrCorrupt, si.ID, si.State)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: missing segments: %s", errs.ErrCorrupt, strings.Join(missing, ", "))
+	}
+	for _, id := range onDisk {
+		if !listed[id] {
+			m.orphans = append(m.orphans, fs.SegPath(m.base, id))
+			// 不复用孤儿文件的 id。
+			m.grow(id)
 		}
-		m.segs = append(m.segs, seg)
 	}
 	return nil
 }
 
+// Refresh 只读时重新读取 manifest：打开写者新登记的段，把已退役的段移出存活列表
+// （映射保留到 Close，调用方仍可读完其中尚未解析的记录）。返回新打开的段数。
+func (m *Manager) Refresh() (int, error) {
+	if !m.readOnly {
+		return 0, nil
+	}
+	mf, err := manifest.Load(fs.ManifestPath(m.base))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// 旧版本库没有 manifest，也就不会有写者追加新段。
+			return 0, nil
+		}
+		return 0, err
+	}
+	n := 0
+	for _, si := range mf.Segs {
+		cur := m.Seg(si.ID)
+		switch si.State {
+		case manifest.Retired:
+			if cur != nil {
+				m.segs[si.ID] = nil
+				m.retired = append(m.retired, cur)
+				m.retiredIDs = append(m.retiredIDs, si.ID)
+			}
+		case manifest.Active, manifest.Sealed:
+			if cur != nil || m.Mapped(si.ID) != nil {
+				continue
+			}
+			if err := m.openOne(si.ID, si.Size); err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// openOne 打开段 id 并放到 segs[id]。
+func (m *Manager) openOne(id uint32, size int64) error {
+	p := fs.SegPath(m.base, id)
+	if size != m.segSize {
+		return fmt.Errorf("%w: manifest size %d != %d: %s", errs.ErrMismatch, size, m.segSize, p)
+	}
+	seg, err := openSegment(p, id, size, false, m.readOnly, DBID{}, m.perm)
+	if err != nil {
+		return err
+	}
+	if err := m.adoptID(seg); err != nil {
+		_ = seg.Close()
+		return err
+	}
+	m.grow(id)
+	m.segs[id] = seg
+	return nil
+}
+
+// grow 保证 segs 至少容纳到下标 id。
+func (m *Manager) grow(id uint32) {
+	for uint32(len(m.segs)) <= id {
+		m.segs = append(m.segs, nil)
+	}
+}
+
+// saveManifest 按当前段状态原子重写 manifest。
+func (m *Manager) saveManifest() error {
+	mf := &manifest.Manifest{Version: manifest.Version}
+	if !m.dbID.IsZero() {
+		mf.DBID = m.dbID.String()
+	}
+	retired := make(map[uint32]bool, len(m.retiredIDs))
+	for _, id := range m.retiredIDs {
+		retired[id] = true
+	}
+	last := m.Last()
+	for id, seg := range m.segs {
+		switch {
+		case seg == last && seg != nil:
+			mf.Segs = append(mf.Segs, manifest.SegInfo{ID: uint32(id), State: manifest.Active, Size: int64(seg.DataLen())})
+		case seg != nil:
+			mf.Segs = append(mf.Segs, manifest.SegInfo{ID: uint32(id), State: manifest.Sealed, Size: int64(seg.DataLen())})
+		case retired[uint32(id)]:
+			mf.Segs = append(mf.Segs, manifest.SegInfo{ID: uint32(id), State: manifest.Retired, Size: m.segSize})
+		}
+	}
+	return manifest.Save(fs.ManifestPath(m.base), mf, m.perm)
+}
+
+// adoptID 校验段的库标识，并在首次遇到时记录下来；旧格式段不参与校验。
+func (m *Manager) adoptID(seg *Segment) error {
+	id := seg.Super().DBID
+	if seg.Legacy() || id.IsZero() {
+		return nil
+	}
+	if m.dbID.IsZero() {
+		m.dbID = id
+		return nil
+	}
+	if id != m.dbID {
+		return fmt.Errorf("%w: %s belongs to db %s, want %s", errs.ErrMismatch, seg.path, id, m.dbID)
+	}
+	return nil
+}
+
+// create 以当前库标识创建段 id，必要时先生成库标识；返回前新文件及其目录项已落盘。
+func (m *Manager) create(id uint32) (*Segment, error) {
+	if m.dbID.IsZero() {
+		dbID, err := NewDBID()
+		if err != nil {
+			return nil, err
+		}
+		m.dbID = dbID
+	}
+	seg, err := openSegment(fs.SegPath(m.base, id), id, m.segSize, true, false, m.dbID, m.perm)
+	if err != nil {
+		return nil, err
+	}
+	if err := seg.syncNew(); err != nil {
+		_ = seg.Close()
+		return nil, err
+	}
+	return seg, nil
+}
+
 // EnsureOne 若尚无段则创建 base.000。
+// 只读时没有段视为库不存在。
 func (m *Manager) EnsureOne() error {
 	if len(m.segs) > 0 {
 		return nil
 	}
-	p := fs.SegPath(m.base, 0)
-	seg, err := OpenSegment(p, 0, m.segSize, true)
+	if m.readOnly {
+		return fmt.Errorf("db: no segments for %s: %w", m.base, os.ErrNotExist)
+	}
+	seg, err := m.create(0)
 	if err != nil {
 		return err
 	}
 	m.segs = append(m.segs, seg)
-	return nil
+	return m.saveManifest()
 }
 
-// Segments 返回当前段列表（只读）。
+// Segments 返回当前存活段列表（按 id 升序，不含已退役段）。
 func (m *Manager) Segments() []*Segment {
-	return m.segs
+	out := make([]*Segment, 0, len(m.segs))
+	for _, seg := range m.segs {
+		if seg != nil {
+			out = append(out, seg)
+		}
+	}
+	return out
 }
 
-// Last 返回最后一个段。
-func (m *Manager) Last() *Segment {
-	if len(m.segs) == 0 {
+// Seg 按 id 返回存活段，不存在或已退役时返回 nil。
+func (m *Manager) Seg(id uint32) *Segment {
+	if int(id) >= len(m.segs) {
 		return nil
 	}
-	return m.segs[len(m.segs)-1]
+	return m.segs[id]
+}
+
+// Mapped 按 id 返回仍处于映射状态的段，包括已退役但尚未 Close 的段。
+func (m *Manager) Mapped(id uint32) *Segment {
+	if seg := m.Seg(id); seg != nil {
+		return seg
+	}
+	for _, seg := range m.retired {
+		if seg.id == id {
+			return seg
+		}
+	}
+	return nil
 }
 
-// ApnSeg 追加一个新段。
+// Last 返回最后一个段（活跃段）。
+func (m *Manager) Last() *Segment {
+	for i := len(m.segs) - 1; i >= 0; i-- {
+		if m.segs[i] != nil {
+			return m.segs[i]
+		}
+	}
+	return nil
+}
+
+// ApnSeg 追加一个新段，并在 manifest 中把原活跃段标记为 sealed。
 func (m *Manager) ApnSeg() (*Segment, error) {
-	id := uint32(len(m.segs))
-	p := fs.SegPath(m.base, id)
-	seg, err := OpenSegment(p, id, m.segSize, true)
+	if m.readOnly {
+		return nil, errs.ErrReadOnly
+	}
+	if m.maxSegs > 0 {
+		n := 0
+		for _, seg := range m.segs {
+			if seg != nil && !seg.Retiring() {
+				n++
+			}
+		}
+		if n >= m.maxSegs {
+			return nil, fmt.Errorf("%w: segment limit %d reached", errs.ErrNoSpace, m.maxSegs)
+		}
+	}
+	seg, err := m.create(uint32(len(m.segs)))
 	if err != nil {
 		return nil, err
 	}
 	m.segs = append(m.segs, seg)
+	if err := m.saveManifest(); err != nil {
+		// manifest 未登记的段重开时会被当作孤儿忽略，不能让后续写入落进去。
+		m.segs = m.segs[:len(m.segs)-1]
+		_ = seg.Close()
+		_ = os.Remove(seg.path)
+		return nil, err
+	}
 	return seg, nil
 }
 
-// Close 关闭所有段。
+// Alloc 全局分配 value 区块，log 记录总是写在活跃段。
+// 依次尝试：活跃段 freelist、其它段 freelist（新段优先）、活跃段尾部；
+// 活跃段 log 区放不下 logNeed 时直接失败，由调用方追加新段。
+func (m *Manager) Alloc(n uint32, logNeed uint64) (*Segment, uint64, bool) {
+	last := m.Last()
+	if last == nil || last.LogEnd()+logNeed > last.ValEnd() {
+		return nil, 0, false
+	}
+	if off, ok := last.AllocFree(n); ok {
+		return last, off, true
+	}
+	for i := len(m.segs) - 1; i >= 0; i-- {
+		seg := m.segs[i]
+		if seg == nil || seg == last || seg.Retiring() {
+			continue
+		}
+		if off, ok := seg.AllocFree(n); ok {
+			return seg, off, true
+		}
+	}
+	if off, ok := last.Alloc(n, logNeed); ok {
+		return last, off, true
+	}
+	return nil, 0, false
+}
+
+// Retire 退役段 id：从存活列表移除，先在 manifest 中记为 retired 再删除段文件及其 hint。
+// 映射保留到 ReleaseRetired 或 Close，以保证调用方手里的零拷贝切片仍然可读；
+// 在此之前被删除的文件仍占用磁盘空间与地址空间。
+func (m *Manager) Retire(id uint32) error {
+	if m.readOnly {
+		return errs.ErrReadOnly
+	}
+	seg := m.Seg(id)
+	if seg == nil || seg == m.Last() {
+		return nil
+	}
+	m.segs[id] = nil
+	m.retired = append(m.retired, seg)
+	m.retiredIDs = append(m.retiredIDs, id)
+	if err := m.saveManifest(); err != nil {
+		// manifest 仍记着该段存活，内存状态须与之一致。
+		m.segs[id] = seg
+		m.retired = m.retired[:len(m.retired)-1]
+		m.retiredIDs = m.retiredIDs[:len(m.retiredIDs)-1]
+		return err
+	}
+	if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(fs.HintPath(m.base, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Retired 返回已退役但仍保持映射的段数及其映射的字节数。
+func (m *Manager) Retired() (n int, size uint64) {
+	for _, seg := range m.retired {
+		size += uint64(seg.DataLen())
+	}
+	return len(m.retired), size
+}
+
+// ReleaseRetired 关闭已退役的段，解除映射并释放文件描述符，被删除的段文件随即归还磁盘空间。
+// 返回关闭的段数；调用方须保证不再有人引用这些段的映射。
+func (m *Manager) ReleaseRetired() (int, error) {
+	n := 0
+	for len(m.retired) > 0 {
+		if err := m.retired[0].Close(); err != nil {
+			return n, err
+		}
+		m.retired = m.retired[1:]
+		n++
+	}
+	m.retired = nil
+	return n, nil
+}
+
+// Close 关闭所有段（含已退役但仍映射的段）。
 func (m *Manager) Close() error {
 	var firstErr error
-	for _, seg := range m.segs {
+	for _, seg := range append(m.segs, m.retired...) {
 		if seg != nil {
 			if err := seg.Close(); err != nil && firstErr == nil {
 				firstErr = err
@@ -86,5 +459,6 @@ func (m *Manager) Close() error {
 		}
 	}
 	m.segs = nil
+	m.retired = nil
 	return firstErr
 }
@@ -3,19 +3,40 @@ package segment
 import (
 	"fmt"
 	"os"
+	"shm_master/consts"
+	"shm_master/internal/errs"
 	"shm_master/internal/mmap"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// Segment 单段：mmap 文件、log/value 边界、freelist。
+// Segment 单段：超级块、mmap 文件、log/value 边界、freelist。
 type Segment struct {
 	id     uint32
 	path   string
 	f      *os.File
 	data   []byte
-	logEnd uint64
-	valEnd uint64
+	super  Super
+	legacy bool
 	free   map[uint32][]uint64
 	truth  map[uint64]uint32
+	dirty  []Span // 已写入、尚未刷盘的范围，按偏移有序、互不相邻，受调用方的写锁保护
+	// readOnly 为 true 时映射为 PROT_READ，任何写入都会触发 SIGSEGV。
+	readOnly bool
+
+	// 以下计数由持有写锁的调用方修改，Stats 不持写锁读取，因此用原子变量或 classMu 保护。
+	logEnd atomic.Uint64
+	valEnd atomic.Uint64
+	live   atomic.Uint64
+	keys   atomic.Uint64 // log 记录位于本段的存活 key 数
+	tombs  atomic.Uint64 // 本段 log 中的墓碑数
+	// retiring 为 true 时段正在被压缩，全局分配器不再从中分配。
+	retiring atomic.Bool
+	classMu  sync.Mutex
+	classes  map[uint32]int // freelist 各档位的空闲块数，与 truth 同步维护，受 classMu 保护
+	freeSize uint64         // freelist 总字节数，受 classMu 保护
 }
 
 // ID 返回段 id。
@@ -24,17 +45,81 @@ func (s *Segment) ID() uint32 { return s.id }
 // GetData 返回 mmap 切片（供 engine 读写），Close 后勿用。
 func (s *Segment) GetData() []byte { return s.data }
 
+// Super 返回段超级块；旧格式段返回零值。
+func (s *Segment) Super() Super { return s.super }
+
+// Legacy 报告段是否为没有超级块的旧格式。
+func (s *Segment) Legacy() bool { return s.legacy }
+
+// LogStart 返回 log 区起点（超级块之后，旧格式段为 0）。
+func (s *Segment) LogStart() uint64 {
+	if s.legacy {
+		return 0
+	}
+	return consts.SuperSize
+}
+
 // LogEnd 返回 log 区当前末尾。
-func (s *Segment) LogEnd() uint64 { return s.logEnd }
+func (s *Segment) LogEnd() uint64 { return s.logEnd.Load() }
 
 // SetLogEnd 设置 log 区末尾（Recover 用）。
-func (s *Segment) SetLogEnd(v uint64) { s.logEnd = v }
+func (s *Segment) SetLogEnd(v uint64) { s.logEnd.Store(v) }
 
 // ValEnd 返回 value 区当前末尾。
-func (s *Segment) ValEnd() uint64 { return s.valEnd }
+func (s *Segment) ValEnd() uint64 { return s.valEnd.Load() }
 
 // SetValEnd 设置 value 区末尾（Recover 用）。
-func (s *Segment) SetValEnd(v uint64) { s.valEnd = v }
+func (s *Segment) SetValEnd(v uint64) { s.valEnd.Store(v) }
+
+// Live 返回仍被索引引用的字节数（value 档位大小 + 记录长度）。
+func (s *Segment) Live() uint64 { return s.live.Load() }
+
+// AddLive 增加存活字节数。
+func (s *Segment) AddLive(n uint64) { s.live.Add(n) }
+
+// SubLive 减少存活字节数。
+func (s *Segment) SubLive(n uint64) { s.live.Store(s.live.Load() - min(n, s.live.Load())) }
+
+// Keys 返回 log 记录位于本段的存活 key 数。
+func (s *Segment) Keys() uint64 { return s.keys.Load() }
+
+// AddKey 存活 key 数加一。
+func (s *Segment) AddKey() { s.keys.Add(1) }
+
+// SubKey 存活 key 数减一。
+func (s *Segment) SubKey() {
+	if s.keys.Load() > 0 {
+		s.keys.Add(^uint64(0))
+	}
+}
+
+// Tombs 返回本段 log 中的墓碑数。
+func (s *Segment) Tombs() uint64 { return s.tombs.Load() }
+
+// AddTomb 墓碑数加一，写入或重放 Del 记录时调用。
+func (s *Segment) AddTomb() { s.tombs.Add(1) }
+
+// ResetLive 清零存活字节数、存活 key 数与墓碑数（Recover 前调用）。
+func (s *Segment) ResetLive() {
+	s.live.Store(0)
+	s.keys.Store(0)
+	s.tombs.Store(0)
+}
+
+// Retiring 报告段是否正在被压缩。
+func (s *Segment) Retiring() bool { return s.retiring.Load() }
+
+// SetRetiring 设置压缩标记。
+func (s *Segment) SetRetiring(v bool) { s.retiring.Store(v) }
+
+// DeadBytes 返回已写入但不再被引用的字节数（log 区 + value 区）。
+func (s *Segment) DeadBytes() uint64 {
+	used := s.LogEnd() - s.LogStart() + uint64(s.DataLen()) - s.ValEnd()
+	if live := s.Live(); live < used {
+		return used - live
+	}
+	return 0
+}
 
 // DataLen 返回 mmap 长度，data 为 nil 时返回 0。
 func (s *Segment) DataLen() int {
@@ -44,80 +129,141 @@ func (s *Segment) DataLen() int {
 	return len(s.data)
 }
 
-// OpenSegment 打开或创建 segment 文件。
+// OpenSegment 打开或创建 segment 文件，新建的段写入不绑定库标识的超级块。
 func OpenSegment(path string, id uint32, segSize int64, create bool) (*Segment, error) {
+	return openSegment(path, id, segSize, create, false, DBID{}, DefaultFileMode)
+}
+
+// openSegment 打开或创建 segment 文件。新文件写入带 dbID 的超级块；
+// 已有文件校验超级块中的段大小与段 id，没有超级块的旧格式段从 0 开始解析 log。
+// readOnly 时以只读方式打开并映射，不能与 create 同时使用；perm 为新建文件的权限。
+func openSegment(path string, id uint32, segSize int64, create, readOnly bool, dbID DBID, perm os.FileMode) (*Segment, error) {
+	if segSize <= consts.SuperSize || (create && readOnly) {
+		return nil, errs.ErrBadArgument
+	}
 	flag := os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
+	}
 	if create {
 		flag |= os.O_CREATE
 	}
-	f, err := os.OpenFile(path, flag, 0644)
+	f, err := os.OpenFile(path, flag, perm)
 	if err != nil {
 		return nil, err
 	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	fresh := create && st.Size() == 0
 	if create {
 		if err := f.Truncate(segSize); err != nil {
 			_ = f.Close()
 			return nil, err
 		}
-	} else {
-		st, err := f.Stat()
-		if err != nil {
-			_ = f.Close()
-			return nil, err
-		}
-		if st.Size() != segSize {
-			_ = f.Close()
-			return nil, fmt.Errorf("segment size mismatch: %s", path)
-		}
+	} else if st.Size() != segSize {
+		_ = f.Close()
+		return nil, fmt.Errorf("%w: size %d != %d: %s", errs.ErrMismatch, st.Size(), segSize, path)
+	}
+	mapFn := mmap.Map
+	if readOnly {
+		mapFn = mmap.MapReadOnly
 	}
-	data, err := mmap.Map(f.Fd(), int(segSize))
+	data, err := mapFn(f.Fd(), int(segSize))
 	if err != nil {
 		_ = f.Close()
 		return nil, err
 	}
-	return &Segment{
-		id:     id,
-		path:   path,
-		f:      f,
-		data:   data,
-		logEnd: 0,
-		valEnd: uint64(len(data)),
-		free:   make(map[uint32][]uint64),
-		truth:  make(map[uint64]uint32),
-	}, nil
+	s := &Segment{
+		id:       id,
+		path:     path,
+		f:        f,
+		data:     data,
+		free:     make(map[uint32][]uint64),
+		truth:    make(map[uint64]uint32),
+		classes:  make(map[uint32]int),
+		readOnly: readOnly,
+	}
+	s.valEnd.Store(uint64(len(data)))
+	if fresh {
+		s.super = Super{
+			Version:   consts.SuperVersion,
+			SegSize:   segSize,
+			SegID:     id,
+			CreatedAt: time.Now().UnixNano(),
+			DBID:      dbID,
+		}
+		EncodeSuper(data, s.super)
+	} else if err := s.checkSuper(segSize); err != nil {
+		_ = mmap.Unmap(data)
+		_ = f.Close()
+		return nil, err
+	}
+	s.logEnd.Store(s.LogStart())
+	return s, nil
 }
 
-// Alloc 分配 value 区块，不命中 freelist 则从尾部分配。
+// checkSuper 解析并校验已有段的超级块。
+func (s *Segment) checkSuper(segSize int64) error {
+	sb, ok, corrupt := DecodeSuper(s.data)
+	switch {
+	case !ok:
+		s.legacy = true
+		return nil
+	case corrupt:
+		return fmt.Errorf("%w: bad superblock: %s", errs.ErrCorrupt, s.path)
+	case sb.Version > consts.SuperVersion:
+		return fmt.Errorf("%w: unsupported format version %d: %s", errs.ErrMismatch, sb.Version, s.path)
+	case sb.SegSize != segSize:
+		return fmt.Errorf("%w: superblock size %d != %d: %s", errs.ErrMismatch, sb.SegSize, segSize, s.path)
+	case sb.SegID != s.id:
+		return fmt.Errorf("%w: superblock id %d != %d: %s", errs.ErrMismatch, sb.SegID, s.id, s.path)
+	}
+	s.super = sb
+	return nil
+}
+
+// Alloc 分配 value 区块，不命中 freelist 则从尾部分配；logNeed 为需同时留给 log 区的字节数。
 func (s *Segment) Alloc(n uint32, logNeed uint64) (off uint64, ok bool) {
 	c := SizeClass(n)
 	if c == 0 {
 		return 0, false
 	}
-	if s.logEnd+logNeed <= s.valEnd {
-		stack := s.free[c]
-		for len(stack) > 0 {
-			off = stack[len(stack)-1]
-			stack = stack[:len(stack)-1]
-			if cls, ok := s.truth[off]; ok && cls == c {
-				delete(s.truth, off)
-				s.free[c] = stack
-				return off, true
-			}
-		}
-		s.free[c] = stack
-	} else {
+	logEnd, valEnd := s.LogEnd(), s.ValEnd()
+	if logEnd+logNeed > valEnd {
 		return 0, false
 	}
+	if off, ok = s.AllocFree(n); ok {
+		return off, true
+	}
 	need := uint64(c)
-	if s.valEnd < need {
+	if valEnd < need || logEnd+logNeed > valEnd-need {
 		return 0, false
 	}
-	newValEnd := s.valEnd - need
-	if s.logEnd+logNeed > newValEnd {
+	s.valEnd.Store(valEnd - need)
+	return valEnd - need, true
+}
+
+// AllocFree 只从 freelist 分配同档位的空闲块，不移动 valEnd。
+func (s *Segment) AllocFree(n uint32) (off uint64, ok bool) {
+	c := SizeClass(n)
+	if c == 0 {
 		return 0, false
 	}
-	s.valEnd = newValEnd
-	return s.valEnd, true
+	stack := s.free[c]
+	for len(stack) > 0 {
+		off = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if cls, ok := s.truth[off]; ok && cls == c {
+			s.delTruth(off)
+			s.free[c] = stack
+			return off, true
+		}
+	}
+	s.free[c] = stack
+	return 0, false
 }
 
 // FreeBlock 释放块并加入 freelist；double-free 忽略。
@@ -129,16 +275,55 @@ func (s *Segment) FreeBlock(off uint64, n uint32) {
 	if cls, ok := s.truth[off]; ok && cls == c {
 		return
 	}
+	s.delTruth(off)
 	s.truth[off] = c
+	s.classMu.Lock()
+	s.classes[c]++
+	s.freeSize += uint64(c)
+	s.classMu.Unlock()
 	s.free[c] = append(s.free[c], off)
 }
 
+// delTruth 从 truth 中删除 off 处的空闲块，并同步档位计数。
+func (s *Segment) delTruth(off uint64) {
+	c, ok := s.truth[off]
+	if !ok {
+		return
+	}
+	delete(s.truth, off)
+	s.classMu.Lock()
+	if s.classes[c]--; s.classes[c] == 0 {
+		delete(s.classes, c)
+	}
+	s.freeSize -= uint64(c)
+	s.classMu.Unlock()
+}
+
+// FreeClass freelist 中某一档位的空闲块。
+type FreeClass struct {
+	Class  uint32 // 档位大小（字节）
+	Blocks int
+}
+
+// FreeClasses 按档位从小到大汇总 freelist，返回总字节数。只读取各档位的计数，可与写入并发调用。
+func (s *Segment) FreeClasses() ([]FreeClass, uint64) {
+	s.classMu.Lock()
+	out := make([]FreeClass, 0, len(s.classes))
+	for c, n := range s.classes {
+		out = append(out, FreeClass{Class: c, Blocks: n})
+	}
+	total := s.freeSize
+	s.classMu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Class < out[j].Class })
+	return out, total
+}
+
 // MarkUsed 标记 offset 已占用（Recover 用）。
 func (s *Segment) MarkUsed(off uint64) {
-	delete(s.truth, off)
+	s.delTruth(off)
 }
 
-// ResetFreeTruth 清空 freelist 与 truth（Recover 前对最后段调用）。
+// ResetFreeTruth 清空 freelist 与 truth（Recover 前对每个段调用）。
 func (s *Segment) ResetFreeTruth() {
 	for c := range s.free {
 		delete(s.free, c)
@@ -146,13 +331,19 @@ func (s *Segment) ResetFreeTruth() {
 	for off := range s.truth {
 		delete(s.truth, off)
 	}
+	s.classMu.Lock()
+	clear(s.classes)
+	s.freeSize = 0
+	s.classMu.Unlock()
 }
 
 // Close 刷盘、解除映射、关闭文件。
 func (s *Segment) Close() error {
 	if s.data != nil {
-		if err := mmap.Sync(s.data); err != nil {
-			return err
+		if !s.readOnly {
+			if err := mmap.Sync(s.data); err != nil {
+				return err
+			}
 		}
 		if err := mmap.Unmap(s.data); err != nil {
 			return err
@@ -169,3 +360,10 @@ func (s *Segment) Close() error {
 	s.truth = nil
 	return nil
 }
+
+// FreeBlocks 遍历 freelist 中的空闲块（偏移与档位大小），顺序不定。
+func (s *Segment) FreeBlocks(fn func(off uint64, class uint32)) {
+	for off, c := range s.truth {
+		fn(off, c)
+	}
+}
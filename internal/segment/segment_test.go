@@ -1,8 +1,12 @@
 package segment
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"shm_master/consts"
+	"shm_master/internal/errs"
+	"slices"
 	"testing"
 )
 
@@ -23,7 +27,7 @@ func TestOpenSegmentCreate(t *testing.T) {
 	if st.Size() != testSegSize {
 		t.Errorf("segment size: got %d want %d", st.Size(), testSegSize)
 	}
-	if seg.ValEnd() != uint64(testSegSize) || seg.LogEnd() != 0 || seg.DataLen() != testSegSize {
+	if seg.ValEnd() != uint64(testSegSize) || seg.LogEnd() != consts.SuperSize || seg.DataLen() != testSegSize {
 		t.Errorf("ValEnd=%d LogEnd=%d DataLen=%d", seg.ValEnd(), seg.LogEnd(), seg.DataLen())
 	}
 }
@@ -38,7 +42,7 @@ func TestOpenSegmentExisting(t *testing.T) {
 		t.Fatalf("open existing: %v", err)
 	}
 	defer seg2.Close()
-	if seg2.ValEnd() != uint64(testSegSize) || seg2.LogEnd() != 0 {
+	if seg2.ValEnd() != uint64(testSegSize) || seg2.LogEnd() != consts.SuperSize {
 		t.Errorf("ValEnd=%d LogEnd=%d", seg2.ValEnd(), seg2.LogEnd())
 	}
 }
@@ -54,6 +58,66 @@ func TestOpenSegmentSizeMismatch(t *testing.T) {
 	}
 }
 
+func TestOpenSegmentSuperblock(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "seg.007")
+	seg, err := OpenSegment(path, 7, testSegSize, true)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	seg.Close()
+	seg, err = OpenSegment(path, 7, testSegSize, false)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	sb := seg.Super()
+	seg.Close()
+	if seg.Legacy() || sb.SegID != 7 || sb.SegSize != testSegSize || sb.Version != consts.SuperVersion || sb.CreatedAt == 0 {
+		t.Errorf("unexpected superblock %+v legacy=%v", sb, seg.Legacy())
+	}
+	if _, err := OpenSegment(path, 8, testSegSize, false); !errors.Is(err, errs.ErrMismatch) {
+		t.Errorf("renamed segment: want ErrMismatch, got %v", err)
+	}
+}
+
+func TestOpenSegmentLegacy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "seg.009")
+	if err := os.WriteFile(path, make([]byte, testSegSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	seg, err := OpenSegment(path, 9, testSegSize, false)
+	if err != nil {
+		t.Fatalf("open legacy: %v", err)
+	}
+	defer seg.Close()
+	if !seg.Legacy() || seg.LogStart() != 0 || seg.LogEnd() != 0 {
+		t.Errorf("legacy=%v LogStart=%d LogEnd=%d", seg.Legacy(), seg.LogStart(), seg.LogEnd())
+	}
+}
+
+func TestManagerRejectsForeignSegment(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		m := NewManager(filepath.Join(dir, name), testSegSize)
+		if err := m.EnsureOne(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.ApnSeg(); err != nil {
+			t.Fatal(err)
+		}
+		m.Close()
+	}
+	if err := os.Rename(filepath.Join(dir, "b.001"), filepath.Join(dir, "a.001")); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(filepath.Join(dir, "a"), testSegSize)
+	defer m.Close()
+	if err := m.OpenBase(); !errors.Is(err, errs.ErrMismatch) {
+		t.Errorf("want ErrMismatch for foreign segment, got %v", err)
+	}
+}
+
 func TestSegmentAlloc(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "seg.003")
@@ -137,3 +201,187 @@ func TestSegmentClose(t *testing.T) {
 	}
 	_ = seg.Close()
 }
+
+func TestManagerAllocReusesSealedFreeBlock(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "kv.data")
+	m := NewManager(base, testSegSize)
+	if err := m.EnsureOne(); err != nil {
+		t.Fatalf("EnsureOne: %v", err)
+	}
+	defer m.Close()
+	first, off, ok := m.Alloc(64, 128)
+	if !ok || first.ID() != 0 {
+		t.Fatalf("first alloc: seg=%v ok=%v", first, ok)
+	}
+	if _, err := m.ApnSeg(); err != nil {
+		t.Fatalf("ApnSeg: %v", err)
+	}
+	first.FreeBlock(off, 64)
+	seg, off2, ok := m.Alloc(64, 128)
+	if !ok || seg.ID() != 0 || off2 != off {
+		t.Errorf("expected reuse of seg 0 block %d, got seg=%d off=%d ok=%v", off, seg.ID(), off2, ok)
+	}
+	first.SetRetiring(true)
+	first.FreeBlock(off, 64)
+	seg, _, ok = m.Alloc(64, 128)
+	if !ok || seg.ID() != 1 {
+		t.Errorf("retiring segment must be skipped, got seg=%d ok=%v", seg.ID(), ok)
+	}
+}
+
+func TestManagerManifest(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "kv.data")
+	m := NewManager(base, testSegSize)
+	if err := m.EnsureOne(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := m.ApnSeg(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Retire(1); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+
+	// 中间段退役后，后续段仍然能打开；多出来的文件记为孤儿。
+	orphan := base + ".009"
+	if err := os.WriteFile(orphan, make([]byte, testSegSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m = NewManager(base, testSegSize)
+	if err := m.OpenBase(); err != nil {
+		t.Fatalf("OpenBase: %v", err)
+	}
+	if got := len(m.Segments()); got != 3 || m.Last().ID() != 3 {
+		t.Errorf("segments=%d last=%d", got, m.Last().ID())
+	}
+	if len(m.Orphans()) != 1 || m.Orphans()[0] != orphan {
+		t.Errorf("orphans=%v", m.Orphans())
+	}
+	seg, err := m.ApnSeg()
+	if err != nil || seg.ID() != 10 {
+		t.Errorf("ApnSeg should skip orphan id, got %v err=%v", seg, err)
+	}
+	m.Close()
+
+	if err := os.Remove(base + ".002"); err != nil {
+		t.Fatal(err)
+	}
+	m = NewManager(base, testSegSize)
+	defer m.Close()
+	if err := m.OpenBase(); !errors.Is(err, errs.ErrCorrupt) {
+		t.Errorf("missing segment: want ErrCorrupt, got %v", err)
+	}
+}
+
+func TestManagerApnSegManifestFailure(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "kv.data")
+	m := NewManager(base, testSegSize)
+	defer m.Close()
+	if err := m.EnsureOne(); err != nil {
+		t.Fatal(err)
+	}
+	// manifest 的临时文件路径被目录占住，保存必然失败。
+	if err := os.Mkdir(base+".MANIFEST.tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ApnSeg(); err == nil {
+		t.Fatal("ApnSeg succeeded without saving the manifest")
+	}
+	if last := m.Last(); last == nil || last.ID() != 0 || len(m.Segments()) != 1 {
+		t.Errorf("active segment after failed ApnSeg: %v, %d segments", last, len(m.Segments()))
+	}
+	if _, err := os.Stat(base + ".001"); !os.IsNotExist(err) {
+		t.Errorf("segment file left behind: %v", err)
+	}
+}
+
+func TestManagerRetireManifestFailure(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "kv.data")
+	m := NewManager(base, testSegSize)
+	defer m.Close()
+	if err := m.EnsureOne(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ApnSeg(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(base+".MANIFEST.tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Retire(0); err == nil {
+		t.Fatal("Retire succeeded without saving the manifest")
+	}
+	if m.Seg(0) == nil || len(m.Segments()) != 2 {
+		t.Errorf("segment 0 not restored after failed Retire: %d segments", len(m.Segments()))
+	}
+	if n, _ := m.Retired(); n != 0 || len(m.retiredIDs) != 0 {
+		t.Errorf("failed Retire left %d retired segments, ids %v", n, m.retiredIDs)
+	}
+	if _, err := os.Stat(base + ".000"); err != nil {
+		t.Errorf("segment file removed: %v", err)
+	}
+}
+
+func TestSegmentMarkDirty(t *testing.T) {
+	seg, err := OpenSegment(filepath.Join(t.TempDir(), "seg.000"), 0, testSegSize, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.Close()
+	seg.SetValEnd(testSegSize - 1024)
+	seg.MarkDirty(64, 100)
+	seg.MarkDirty(164, 50)              // log 区，合并
+	seg.MarkDirty(testSegSize-32, 32)   // value 区
+	seg.MarkDirty(testSegSize-1024, 32) // 同一页内的 value 块，合并
+	seg.MarkDirty(32<<10, 32)           // 远处复用的空闲块，单独成段
+	want := []Span{{64, 150}, {32 << 10, 32}, {testSegSize - 1024, 1024}}
+	if !slices.Equal(seg.dirty, want) {
+		t.Fatalf("dirty = %v, want %v", seg.dirty, want)
+	}
+	if err := seg.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(seg.dirty) != 0 {
+		t.Errorf("dirty after Sync = %v", seg.dirty)
+	}
+
+	// 超过上限后合并为一个范围。
+	page := uint64(os.Getpagesize())
+	for i := uint64(0); i <= MaxDirtySpans; i++ {
+		seg.MarkDirty(4096+i*2*page, 8)
+	}
+	want = []Span{{4096, MaxDirtySpans*2*page + 8}}
+	if !slices.Equal(seg.dirty, want) {
+		t.Errorf("dirty past limit = %v, want %v", seg.dirty, want)
+	}
+}
+
+func TestSegmentFreeClassCounts(t *testing.T) {
+	seg, err := OpenSegment(filepath.Join(t.TempDir(), "seg.000"), 0, testSegSize, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.Close()
+	seg.FreeBlock(1024, 100) // 112
+	seg.FreeBlock(1024, 100) // double-free 忽略
+	seg.FreeBlock(2048, 32)
+	seg.FreeBlock(4096, 30) // 32
+	seg.FreeBlock(8192, 200)
+	seg.MarkUsed(8192)
+	seg.MarkUsed(9999) // 不在 freelist 中
+	if _, ok := seg.AllocFree(32); !ok {
+		t.Fatal("AllocFree(32) missed")
+	}
+	classes, total := seg.FreeClasses()
+	want := []FreeClass{{Class: 32, Blocks: 1}, {Class: 112, Blocks: 1}}
+	if len(classes) != len(want) || classes[0] != want[0] || classes[1] != want[1] || total != 144 {
+		t.Errorf("classes=%v total=%d, want %v and 144", classes, total, want)
+	}
+	seg.ResetFreeTruth()
+	if classes, total := seg.FreeClasses(); len(classes) != 0 || total != 0 {
+		t.Errorf("after reset: classes=%v total=%d", classes, total)
+	}
+}
@@ -0,0 +1,96 @@
+package segment
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"shm_master/consts"
+	"shm_master/internal/errs"
+)
+
+// DBID 库标识（UUIDv4），同一个库的所有段共享。
+type DBID [16]byte
+
+// NewDBID 生成随机库标识。
+func NewDBID() (DBID, error) {
+	var id DBID
+	if _, err := rand.Read(id[:]); err != nil {
+		return id, err
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return id, nil
+}
+
+// ParseDBID 解析 String 输出的 UUID 文本。
+func ParseDBID(s string) (DBID, error) {
+	var id DBID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return id, errs.ErrBadArgument
+	}
+	raw := s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	if _, err := hex.Decode(id[:], []byte(raw)); err != nil {
+		return id, errs.ErrBadArgument
+	}
+	return id, nil
+}
+
+// IsZero 报告是否为未绑定的零值标识。
+func (id DBID) IsZero() bool { return id == DBID{} }
+
+func (id DBID) String() string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], id[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], id[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], id[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], id[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], id[10:])
+	return string(buf[:])
+}
+
+// Super 段超级块（magic/version/segSize/segID/createdAt/dbID/crc）。
+type Super struct {
+	Version   uint16
+	SegSize   int64
+	SegID     uint32
+	CreatedAt int64 // UnixNano
+	DBID      DBID
+}
+
+// 超级块布局：magic 0..4 ver 4..6 rsv 6..8 segSize 8..16 segID 16..20 rsv 20..24
+// createdAt 24..32 dbID 32..48 crc 48..52，其余填 0。
+const superCRCOff = 48
+
+// EncodeSuper 将 sb 编码到 b（至少 SuperSize 字节）。
+func EncodeSuper(b []byte, sb Super) {
+	clear(b[:consts.SuperSize])
+	binary.LittleEndian.PutUint32(b[0:4], consts.SuperMagic)
+	binary.LittleEndian.PutUint16(b[4:6], sb.Version)
+	binary.LittleEndian.PutUint64(b[8:16], uint64(sb.SegSize))
+	binary.LittleEndian.PutUint32(b[16:20], sb.SegID)
+	binary.LittleEndian.PutUint64(b[24:32], uint64(sb.CreatedAt))
+	copy(b[32:48], sb.DBID[:])
+	binary.LittleEndian.PutUint32(b[superCRCOff:superCRCOff+4], crc32.ChecksumIEEE(b[:superCRCOff]))
+}
+
+// DecodeSuper 从 b 解码超级块。ok 为 false 表示没有超级块（旧格式段）；
+// 有 magic 但 CRC 不符时 corrupt 为 true。
+func DecodeSuper(b []byte) (sb Super, ok bool, corrupt bool) {
+	if len(b) < consts.SuperSize || binary.LittleEndian.Uint32(b[0:4]) != consts.SuperMagic {
+		return Super{}, false, false
+	}
+	if crc32.ChecksumIEEE(b[:superCRCOff]) != binary.LittleEndian.Uint32(b[superCRCOff:superCRCOff+4]) {
+		return Super{}, true, true
+	}
+	sb.Version = binary.LittleEndian.Uint16(b[4:6])
+	sb.SegSize = int64(binary.LittleEndian.Uint64(b[8:16]))
+	sb.SegID = binary.LittleEndian.Uint32(b[16:20])
+	sb.CreatedAt = int64(binary.LittleEndian.Uint64(b[24:32]))
+	copy(sb.DBID[:], b[32:48])
+	return sb, true, false
+}
@@ -0,0 +1,95 @@
+package segment
+
+import (
+	"os"
+	"path/filepath"
+	"shm_master/consts"
+	"shm_master/internal/fs"
+	"shm_master/internal/mmap"
+	"slices"
+	"sort"
+)
+
+// Span 段内一段已写入、尚未刷盘的字节范围。
+type Span struct {
+	Off, Len uint64
+}
+
+// MaxDirtySpans 每段最多记录的待刷盘范围数，超出后合并为一个覆盖全部脏字节的范围。
+const MaxDirtySpans = 16
+
+// MarkDirty 记录 [off, off+n) 已被写入，等待 Sync。与已有范围重叠或相距不足一页的合并，
+// 因此顺序追加的 log 与 value 各只占一个范围，freelist 复用的分散块各自成段，只刷自己所在的页。
+func (s *Segment) MarkDirty(off, n uint64) {
+	if n == 0 || s.readOnly {
+		return
+	}
+	page := uint64(os.Getpagesize())
+	lo, hi := off, off+n
+	i := sort.Search(len(s.dirty), func(i int) bool { return s.dirty[i].Off+s.dirty[i].Len+page >= lo })
+	j := i
+	for ; j < len(s.dirty) && s.dirty[j].Off <= hi+page; j++ {
+		lo = min(lo, s.dirty[j].Off)
+		hi = max(hi, s.dirty[j].Off+s.dirty[j].Len)
+	}
+	s.dirty = slices.Replace(s.dirty, i, j, Span{Off: lo, Len: hi - lo})
+	if len(s.dirty) > MaxDirtySpans {
+		first, last := s.dirty[0], s.dirty[len(s.dirty)-1]
+		s.dirty = append(s.dirty[:0], Span{Off: first.Off, Len: last.Off + last.Len - first.Off})
+	}
+}
+
+// TakeDirty 取出并清空待刷盘范围。
+func (s *Segment) TakeDirty() []Span {
+	out := s.dirty
+	s.dirty = nil
+	return out
+}
+
+// SyncSpans 把 spans 覆盖的页刷回磁盘；落在同一页或相邻页的范围合并为一次 msync。
+func (s *Segment) SyncSpans(spans []Span) error {
+	if len(spans) == 0 || s.data == nil {
+		return nil
+	}
+	page := uint64(os.Getpagesize())
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Off < spans[j].Off })
+	lo := spans[0].Off &^ (page - 1)
+	hi := spans[0].Off + spans[0].Len
+	for _, sp := range spans[1:] {
+		if sp.Off&^(page-1) <= hi {
+			hi = max(hi, sp.Off+sp.Len)
+			continue
+		}
+		if err := mmap.SyncRange(s.data, int(lo), int(hi-lo)); err != nil {
+			return err
+		}
+		lo, hi = sp.Off&^(page-1), sp.Off+sp.Len
+	}
+	return mmap.SyncRange(s.data, int(lo), int(hi-lo))
+}
+
+// Sync 刷盘所有待刷盘范围。
+func (s *Segment) Sync() error {
+	return s.SyncSpans(s.TakeDirty())
+}
+
+// SyncAll 把整个映射刷回磁盘，不改变待刷盘范围，可与写入并发调用；只有脏页会真正写盘。
+func (s *Segment) SyncAll() error {
+	if s.data == nil || s.readOnly {
+		return nil
+	}
+	return mmap.SyncRange(s.data, 0, len(s.data))
+}
+
+// syncNew 让新建的段文件落盘：超级块、文件长度以及目录项，之后才能登记到 manifest。
+func (s *Segment) syncNew() error {
+	if !s.legacy {
+		if err := mmap.SyncRange(s.data, 0, consts.SuperSize); err != nil {
+			return err
+		}
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	return fs.SyncDir(filepath.Dir(s.path))
+}
@@ -0,0 +1,31 @@
+package shm_master
+
+import "iter"
+
+// All 遍历所有存活的 key 与 value。value 为零拷贝切片，语义同 Get。
+// IndexOrdered 下按字典序，否则顺序不定。遍历不阻塞并发的 Set/Del，循环体内也可以读写 DB：
+// 开始前已存在且期间未被删除的 key 恰好出现一次，期间新增或删除的 key 可能出现也可能不出现，
+// value 为访问到该 key 时的最新值。需要一致视图时在 Snapshot 上使用 Range。
+// 读取出错（如 ErrCorrupt）的 key 会被跳过，需要错误信息时使用 Scan。
+func (db *DB) All() iter.Seq2[string, []byte] {
+	if db == nil || db.e == nil {
+		return func(func(string, []byte) bool) {}
+	}
+	return db.e.All()
+}
+
+// Keys 遍历所有存活的 key，不读取 value，并发语义同 All。
+func (db *DB) Keys() iter.Seq[string] {
+	if db == nil || db.e == nil {
+		return func(func(string) bool) {}
+	}
+	return db.e.Keys()
+}
+
+// Prefix 遍历以 p 开头的 key 与 value，顺序与并发语义同 All。
+func (db *DB) Prefix(p string) iter.Seq2[string, []byte] {
+	if db == nil || db.e == nil {
+		return func(func(string, []byte) bool) {}
+	}
+	return db.e.Prefix(p)
+}
@@ -0,0 +1,10 @@
+package shm_master
+
+import "shm_master/internal/engine"
+
+// Metrics 累计操作计数与延迟、ErrNoSpace 次数、段追加次数以及恢复耗时与截断字节数。
+// 通过 Options.Metrics 启用，可被多个 DB 共用；用 Publish 发布到 expvar，或用 Handler 供 Prometheus 抓取。
+type Metrics = engine.Metrics
+
+// NewMetrics 创建一组清零的指标。
+func NewMetrics() *Metrics { return engine.NewMetrics() }
@@ -0,0 +1,48 @@
+package shm_master
+
+import (
+	"shm_master/internal/engine"
+	"shm_master/internal/errs"
+)
+
+// ValueReader 流式读取一个 value，适合超过段大小的分块 value；实现 io.ReadCloser。
+type ValueReader struct {
+	r *engine.ValueReader
+}
+
+// NewReader 打开 key 的流式读取器，key 不存在时 ok 为 false。读取器打开期间会阻止
+// 被覆盖或删除的块被复用（与 Snapshot 相同），用完必须 Close。每块在读取前校验 CRC32C。
+func (db *DB) NewReader(key string) (*ValueReader, bool, error) {
+	if db == nil || db.e == nil {
+		return nil, false, nil
+	}
+	r, ok, err := db.e.NewReader(key)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	return &ValueReader{r: r}, true, nil
+}
+
+// Size 返回 value 总长度。
+func (r *ValueReader) Size() int64 {
+	if r == nil || r.r == nil {
+		return 0
+	}
+	return r.r.Size()
+}
+
+// Read 实现 io.Reader。
+func (r *ValueReader) Read(p []byte) (int, error) {
+	if r == nil || r.r == nil {
+		return 0, errs.ErrClosed
+	}
+	return r.r.Read(p)
+}
+
+// Close 释放读取器。
+func (r *ValueReader) Close() error {
+	if r == nil || r.r == nil {
+		return nil
+	}
+	return r.r.Close()
+}
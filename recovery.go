@@ -0,0 +1,19 @@
+package shm_master
+
+import "shm_master/internal/engine"
+
+// RecoveryReport 打开时 Recover 的结果：每个段由 hint 还是 log 恢复、生效与损坏的记录数、
+// 丢弃的未提交批次，以及 log 在何处、因何停止解析、丢弃了多少字节。
+type RecoveryReport = engine.RecoveryReport
+
+// SegmentRecovery 单个段的恢复结果。
+type SegmentRecovery = engine.SegmentRecovery
+
+// RecoveryReport 返回打开时 Recover 的结果。Open 即使丢弃了被截断的 log 也会成功，
+// 调用方可据此告警，或设置 Options.StrictRecovery 让 Open 直接返回 ErrCorrupt。
+func (db *DB) RecoveryReport() *RecoveryReport {
+	if db == nil || db.e == nil {
+		return &RecoveryReport{}
+	}
+	return db.e.RecoveryReport()
+}
@@ -3,6 +3,7 @@ package shm_master
 import (
 	"shm_master/internal/engine"
 	"shm_master/internal/errs"
+	"time"
 )
 
 // 对外暴露的 sentinel errors，便于调用方 errors.Is。
@@ -11,21 +12,63 @@ var (
 	ErrBadArgument = errs.ErrBadArgument
 	ErrClosed      = errs.ErrClosed
 	ErrCorrupt     = errs.ErrCorrupt
+	ErrMismatch    = errs.ErrMismatch
+	ErrLocked      = errs.ErrLocked
+	ErrReadOnly    = errs.ErrReadOnly
 )
 
+// DefaultCompactRatio 默认压缩阈值：死字节占段大小的比例。
+const DefaultCompactRatio = engine.DefaultCompactRatio
+
 type DB struct {
 	e *engine.DB
 }
 
+// IndexKind 选择内存索引的实现。
+type IndexKind = engine.IndexKind
+
+const (
+	// IndexHash 分片哈希索引（默认）：点查最快，Scan 需要先收集并排序匹配的 key。
+	IndexHash = engine.IndexHash
+	// IndexOrdered 跳表索引：按 key 字典序组织，适合频繁的 Scan/ScanPrefix。
+	IndexOrdered = engine.IndexOrdered
+)
+
+// Options Open 的配置，零值字段取默认值：单段 DefaultSegSize、DefaultShards 个分片的哈希索引、
+// SyncNever、文件权限 0644、不限段数、不输出日志。字段说明见各字段注释。
+type Options = engine.Options
+
+const (
+	// DefaultSegSize 未指定 Options.SegSize 时的单段大小。
+	DefaultSegSize = engine.DefaultSegSize
+	// MinSegSize 允许的最小单段大小。
+	MinSegSize = engine.MinSegSize
+	// DefaultShards 未指定 Options.Shards 时哈希索引的分片数。
+	DefaultShards = engine.DefaultShards
+)
+
 // Open 打开或创建 DB。base 为数据文件路径前缀，segSize 为单段大小（字节）。
+// 写者会对 base.LOCK 加 flock，同一个 base 已有写者时返回 ErrLocked。
 func Open(base string, segSize int64) (*DB, error) {
-	e, err := engine.Open(base, segSize)
+	return OpenWithOptions(base, Options{SegSize: segSize})
+}
+
+// OpenWithOptions 按 opts 打开或创建 DB，opts 非法时返回 ErrBadArgument。
+func OpenWithOptions(base string, opts Options) (*DB, error) {
+	e, err := engine.OpenWithOptions(base, opts)
 	if err != nil {
 		return nil, err
 	}
 	return &DB{e: e}, nil
 }
 
+// OpenReadOnly 只读打开已有的 DB，供写者之外的进程读取：以 PROT_READ 映射段文件，
+// 不加锁、不修改任何文件，写操作返回 ErrReadOnly。看到的是打开时刻的数据；
+// 写者之后复用的块会在 Get 校验 CRC 时以 ErrCorrupt 报告，而不是返回错误的数据。
+func OpenReadOnly(base string, segSize int64) (*DB, error) {
+	return OpenWithOptions(base, Options{SegSize: segSize, ReadOnly: true})
+}
+
 func (db *DB) Close() error {
 	if db == nil || db.e == nil {
 		return nil
@@ -33,12 +76,16 @@ func (db *DB) Close() error {
 	return db.e.Close()
 }
 
+// Get 读取 key。普通 value 返回映射内的零拷贝切片；分块存放的大 value 返回拼接好的拷贝。
 func (db *DB) Get(key string) ([]byte, bool, error) {
 	if db == nil || db.e == nil {
 		return nil, false, nil
 	}
 	return db.e.Get(key)
 }
+
+// Set 写入 key。超过约半个段可用空间的 value 会自动分块，分散存放到多个块乃至多个段中；
+// 分块数受清单大小限制（value 上限约为段大小的平方除以 320），超出时返回 ErrBadArgument。
 func (db *DB) Set(key string, value []byte) error {
 	if db == nil || db.e == nil {
 		return nil
@@ -52,3 +99,71 @@ func (db *DB) Del(key string) error {
 	}
 	return db.e.Del(key)
 }
+
+// Scan 按字典序遍历 [start, end) 内的 key，fn 返回 false 时停止；start/end 为空表示不设界。
+// value 为零拷贝切片，语义同 Get。fn 内可以读写 DB，遍历期间的写入对尚未访问到的 key 可见。
+func (db *DB) Scan(start, end string, fn func(key string, value []byte) bool) error {
+	if db == nil || db.e == nil {
+		return nil
+	}
+	return db.e.Scan(start, end, fn)
+}
+
+// ScanPrefix 按字典序遍历以 prefix 开头的 key，语义同 Scan。
+func (db *DB) ScanPrefix(prefix string, fn func(key string, value []byte) bool) error {
+	if db == nil || db.e == nil {
+		return nil
+	}
+	return db.e.ScanPrefix(prefix, fn)
+}
+
+// Compact 在线压缩死字节比例不低于 minRatio 的已封存段，返回退役的段数。
+// 压缩与 Get/Set 并发执行；此前 Get 返回的切片在 Close 或 ReleaseRetired 之前仍可读取，
+// 退役段的文件虽已删除，在此之前仍占用磁盘空间。
+func (db *DB) Compact(minRatio float64) (int, error) {
+	if db == nil || db.e == nil {
+		return 0, nil
+	}
+	return db.e.Compact(minRatio)
+}
+
+// StartCompactor 启动后台压缩协程，每隔 interval 执行一次 Compact(minRatio)，Close 时停止。
+// 长期运行的进程应在确认不再持有旧切片后调用 ReleaseRetired，归还退役段占用的磁盘空间。
+func (db *DB) StartCompactor(interval time.Duration, minRatio float64) error {
+	if db == nil || db.e == nil {
+		return nil
+	}
+	return db.e.StartCompactor(interval, minRatio)
+}
+
+// ReleaseRetired 解除压缩退役段的映射并关闭其文件，使其磁盘空间真正释放，返回释放的段数。
+// 此前 Get、Scan 等返回的指向这些段的零拷贝切片随之失效，再访问会使进程崩溃；
+// 有快照或 ValueReader 打开时不释放，返回 0。
+func (db *DB) ReleaseRetired() (int, error) {
+	if db == nil || db.e == nil {
+		return 0, nil
+	}
+	return db.e.ReleaseRetired()
+}
+
+// Orphans 返回目录中存在、但未被 manifest 登记的段文件；Open 不会加载它们。
+func (db *DB) Orphans() []string {
+	if db == nil || db.e == nil {
+		return nil
+	}
+	return db.e.Orphans()
+}
+
+// SetVerifyOnGet 开启后 Get/GetFixed 会校验 value 的 CRC32C，不符时返回 ErrCorrupt。
+func (db *DB) SetVerifyOnGet(on bool) {
+	if db == nil || db.e == nil {
+		return
+	}
+	db.e.SetVerifyOnGet(on)
+}
+
+// Upgrade 离线把 base 中的 v1 记录重写为 v2 格式，返回重写的段数。
+// 调用期间不得有其它进程打开同一个 base。
+func Upgrade(base string, segSize int64) (int, error) {
+	return engine.Upgrade(base, segSize)
+}
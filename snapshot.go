@@ -0,0 +1,52 @@
+package shm_master
+
+import (
+	"shm_master/internal/engine"
+	"shm_master/internal/errs"
+)
+
+// Snapshot 固定在某个记录序列号上的只读视图：之后的写入对它不可见。
+// 快照打开期间被覆盖或删除的 value 块不会被复用，TTL 也按创建时刻判断，用完必须调用 Release。
+type Snapshot struct {
+	s *engine.Snapshot
+}
+
+// Snapshot 创建一个反映当前已提交状态的快照。
+func (db *DB) Snapshot() *Snapshot {
+	if db == nil || db.e == nil {
+		return nil
+	}
+	return &Snapshot{s: db.e.Snapshot()}
+}
+
+// Seq 返回快照固定的记录序列号。
+func (s *Snapshot) Seq() uint64 {
+	if s == nil || s.s == nil {
+		return 0
+	}
+	return s.s.Seq()
+}
+
+// Get 按快照视角读取 key。返回的切片为零拷贝，在 Release 之前有效。
+func (s *Snapshot) Get(key string) ([]byte, bool, error) {
+	if s == nil || s.s == nil {
+		return nil, false, errs.ErrClosed
+	}
+	return s.s.Get(key)
+}
+
+// Range 按快照视角遍历所有 key（顺序不定），fn 返回 false 时停止；遍历不阻塞写入。
+func (s *Snapshot) Range(fn func(key string, value []byte) bool) error {
+	if s == nil || s.s == nil {
+		return errs.ErrClosed
+	}
+	return s.s.Range(fn)
+}
+
+// Release 释放快照，归还被它保留的 value 块。重复调用无副作用。
+func (s *Snapshot) Release() {
+	if s == nil || s.s == nil {
+		return
+	}
+	s.s.Release()
+}
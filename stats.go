@@ -0,0 +1,22 @@
+package shm_master
+
+import "shm_master/internal/engine"
+
+// Stats DB 空间占用的快照：各段的 log/value 边界、存活与死字节、按档位的 freelist、
+// 存活 key 数与墓碑数，以及全局合计。
+type Stats = engine.Stats
+
+// SegmentStats 单个存活段的空间占用。
+type SegmentStats = engine.SegmentStats
+
+// FreeClass freelist 中某一档位的空闲块数。
+type FreeClass = engine.FreeClass
+
+// Stats 返回当前的空间占用，不解析 log，适合在监控循环中周期性调用。
+// 写入返回 ErrNoSpace 时，可据此判断是段数达到上限还是死字节过多、需要 Compact。
+func (db *DB) Stats() (Stats, error) {
+	if db == nil || db.e == nil {
+		return Stats{}, nil
+	}
+	return db.e.Stats()
+}
@@ -0,0 +1,23 @@
+package shm_master
+
+import "shm_master/internal/engine"
+
+// SyncPolicy 选择已确认的写入何时刷回磁盘，通过 Options.Sync 设置。
+type SyncPolicy = engine.SyncPolicy
+
+const (
+	// SyncNever 只在 Sync 与 Close 时刷盘（默认）：进程崩溃不丢数据，机器掉电可能丢失未刷盘的写入。
+	SyncNever = engine.SyncNever
+	// SyncInterval 后台每隔 Options.SyncInterval 刷盘一次，掉电最多丢失一个间隔内的写入。
+	SyncInterval = engine.SyncInterval
+	// SyncAlways 每次写入在返回前刷盘。
+	SyncAlways = engine.SyncAlways
+)
+
+// Sync 把此前所有已确认写入所触及的页刷回磁盘，只刷写入过的范围而非整个段。只读 DB 上为空操作。
+func (db *DB) Sync() error {
+	if db == nil || db.e == nil {
+		return nil
+	}
+	return db.e.Sync()
+}
@@ -0,0 +1,44 @@
+package shm_master
+
+import "time"
+
+// SetWithTTL 写入 key，并在 ttl 之后过期。过期时间随记录持久化，Recover 后仍然有效；
+// 过期的 key 对 Get/GetFixed 立即不可见，其空间由 ReapExpired 或 StartReaper 回收。
+func (db *DB) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	if db == nil || db.e == nil {
+		return nil
+	}
+	return db.e.SetWithTTL(key, value, ttl)
+}
+
+// Expire 把已存在的 key 的过期时间设为 ttl 之后，返回 key 是否存在。
+func (db *DB) Expire(key string, ttl time.Duration) (bool, error) {
+	if db == nil || db.e == nil {
+		return false, nil
+	}
+	return db.e.Expire(key, ttl)
+}
+
+// TTL 返回 key 的剩余存活时间；key 不存在或已过期时 ok 为 false，永不过期时返回 0。
+func (db *DB) TTL(key string) (time.Duration, bool, error) {
+	if db == nil || db.e == nil {
+		return 0, false, nil
+	}
+	return db.e.TTL(key)
+}
+
+// ReapExpired 为已过期的 key 写入墓碑并释放其 value 块，返回清理的 key 数。
+func (db *DB) ReapExpired() (int, error) {
+	if db == nil || db.e == nil {
+		return 0, nil
+	}
+	return db.e.ReapExpired()
+}
+
+// StartReaper 启动后台清理协程，每隔 interval 执行一次 ReapExpired，Close 时停止。
+func (db *DB) StartReaper(interval time.Duration) error {
+	if db == nil || db.e == nil {
+		return nil
+	}
+	return db.e.StartReaper(interval)
+}
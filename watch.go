@@ -0,0 +1,33 @@
+package shm_master
+
+import "shm_master/internal/engine"
+
+// Op 变更事件的类型。
+type Op = engine.Op
+
+const (
+	// OpSet key 被写入（Set、SetWithTTL 或批量写中的 Set）。
+	OpSet = engine.OpSet
+	// OpDel key 被删除（Del、批量写中的 Del，或过期后被清理）。
+	OpDel = engine.OpDel
+)
+
+// Event 一次已提交的变更：Key、Op 与对应记录的序列号 Seq。
+type Event = engine.Event
+
+// Watcher 变更订阅，通过 C() 接收事件，用完调用 Close。
+type Watcher = engine.Watcher
+
+// WatchBuffer 每个 Watcher 的事件缓冲长度。
+const WatchBuffer = engine.WatchBuffer
+
+// Watch 订阅以 prefix 开头的 key 的变更，prefix 为空时订阅全部 key。
+// 事件在写入提交后按提交顺序投递，写者从不因订阅方而阻塞：缓冲（WatchBuffer 条）已满时
+// 新事件被丢弃并计入 Watcher.Dropped，订阅方发现丢弃后应重新读取所关心的 key。
+// 只报告本 DB 句柄上的写入；DB 关闭时事件通道随之关闭。
+func (db *DB) Watch(prefix string) *Watcher {
+	if db == nil || db.e == nil {
+		return nil
+	}
+	return db.e.Watch(prefix)
+}